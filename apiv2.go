@@ -0,0 +1,35 @@
+/* Contains the middleware of the versioned API */
+
+package main
+
+import "net/http"
+
+// Response writer marking requests made to API v2
+type apiV2Writer struct {
+	http.ResponseWriter
+}
+
+// Allows http.ResponseController to reach the underlying response writer
+func (w *apiV2Writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flushes the streamed responses, such as HLS segments, on API v2
+func (w *apiV2Writer) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Middleware for API v2 routes
+func apiV2(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&apiV2Writer{w}, r)
+	})
+}
+
+// Check if the response is for API v2
+func isAPIv2(w http.ResponseWriter) bool {
+	_, ok := w.(*apiV2Writer)
+	return ok
+}
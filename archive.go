@@ -0,0 +1,129 @@
+/* Contains the zip and tar streaming of whole torrents or folders */
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/gorilla/mux"
+)
+
+// Returns the completed files of the torrent inside the folder, all of them if folder is empty
+func completedFolderFiles(t *torrent.Torrent, folder string) []*torrent.File {
+	folder = strings.Trim(folder, "/")
+	files := []*torrent.File{}
+	for _, f := range t.Files() {
+		if folder != "" && !strings.HasPrefix(f.DisplayPath(), folder+"/") {
+			continue
+		}
+		if f.BytesCompleted() != f.Length() {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// Writes the files as a zip archive, stored without compression as media is already compressed
+func writeZipArchive(w io.Writer, t *torrent.Torrent, files []*torrent.File) error {
+	zw := zip.NewWriter(w)
+	modTime := torrentModTime(t.InfoHash().String())
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:               f.DisplayPath(),
+			Method:             zip.Store,
+			Modified:           modTime,
+			UncompressedSize64: uint64(f.Length()),
+		})
+		if err != nil {
+			return err
+		}
+		if err := copyTorrentFile(fw, f); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// Writes the files as a tar archive
+func writeTarArchive(w io.Writer, t *torrent.Torrent, files []*torrent.File) error {
+	tw := tar.NewWriter(w)
+	modTime := torrentModTime(t.InfoHash().String())
+	for _, f := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.DisplayPath(),
+			Size:     f.Length(),
+			Mode:     0644,
+			ModTime:  modTime,
+			Format:   tar.FormatPAX,
+		})
+		if err != nil {
+			return err
+		}
+		if err := copyTorrentFile(tw, f); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// Copies the whole content of a torrent file
+func copyTorrentFile(w io.Writer, f *torrent.File) error {
+	reader := f.NewReader()
+	defer reader.Close()
+	// Reads with large buffers can overrun the end of the file
+	_, err := io.Copy(w, io.LimitReader(reader, f.Length()))
+	return err
+}
+
+// Endpoint streaming the completed files of a torrent or folder as a zip or tar archive
+func apiArchive(w http.ResponseWriter, r *http.Request) {
+	/* Get torrent handle from infohash */
+	t, err := btEngine.getTorrHandle(mux.Vars(r)["infohash"])
+	if err != nil {
+		torrHandleErrorRes(w, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "tar" {
+		errorRes(w, "Invalid archive format", http.StatusBadRequest)
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	files := completedFolderFiles(t, folder)
+	if len(files) < 1 {
+		errorRes(w, "No completed files", http.StatusConflict)
+		return
+	}
+
+	/* Name the archive after the torrent and folder */
+	name := torrentName(t)
+	if folder = strings.Trim(folder, "/"); folder != "" {
+		name += " - " + safenDisplayPath(folder)
+	}
+
+	/* Files are streamed one after another without temporary files */
+	var werr error
+	setAttachmentName(w, name+"."+format)
+	if format == "tar" {
+		w.Header().Set("Content-Type", "application/x-tar")
+		werr = writeTarArchive(w, t, files)
+	} else {
+		w.Header().Set("Content-Type", "application/zip")
+		werr = writeZipArchive(w, t, files)
+	}
+	if werr != nil && r.Context().Err() == nil {
+		Warn.Printf("Cannot stream archive of \"%s\": %s\n", name, werr)
+	}
+}
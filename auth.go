@@ -1,9 +1,14 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"time"
 )
 
 // Check if authentication is enabled
@@ -35,17 +40,27 @@ func checkAuth(next http.Handler) http.Handler {
 		// Get API key from HTTP query
 		key := r.URL.Query().Get("key")
 
+		// Signed links are valid without the API key
+		if authEnabled && r.URL.Query().Has("sig") {
+			if !validLinkSignature(r) {
+				errorRes(w, "Signature is not valid or expired", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		if authEnabled {
 			// Unescape the API key
 			unescapedKey, unescapeErr := url.QueryUnescape(key)
 			if unescapeErr != nil {
-				errorRes(w, "Error unescaping the API key", http.StatusInternalServerError)
+				errorRes(w, "Error unescaping the API key", http.StatusBadRequest)
 				return
 			}
 
 			// Check if API key is valid
 			if unescapedKey != apiKey {
-				errorRes(w, "Key is not valid", http.StatusForbidden)
+				errorRes(w, "Key is not valid", http.StatusUnauthorized)
 				return
 			}
 		}
@@ -53,3 +68,41 @@ func checkAuth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Appends an expiring signature to a link in place of the API key
+func signLink(link string, ttl time.Duration) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+
+	exp := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	q := u.Query()
+	q.Del("key")
+	q.Set("exp", exp)
+	q.Set("sig", linkSignature(u.Path, exp))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Check if the request has a valid and unexpired link signature
+func validLinkSignature(r *http.Request) bool {
+	exp := r.URL.Query().Get("exp")
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expUnix {
+		return false
+	}
+	sig, herr := hex.DecodeString(r.URL.Query().Get("sig"))
+	if herr != nil {
+		return false
+	}
+	expected, _ := hex.DecodeString(linkSignature(r.URL.Path, exp))
+	return hmac.Equal(sig, expected)
+}
+
+// HMAC of the link path and its expiry keyed with the API key
+func linkSignature(path string, exp string) string {
+	mac := hmac.New(sha256.New, []byte(apiKey))
+	mac.Write([]byte(path + "\n" + exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,364 @@
+/* Contains the peer management and the persistent IP ban list */
+
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/iplist"
+	"github.com/gorilla/mux"
+)
+
+// IP blocklist of the client whose ranges can change while it runs
+type ipFilter struct {
+	mu   sync.RWMutex
+	bans []netip.Prefix
+
+	// Ranges of the blocklist file, nil if none is loaded
+	list iplist.Ranger
+
+	// Distinct peer IPs refused by the client, as a peer is looked up again on every connection and announce
+	blockedMu    sync.Mutex
+	blockedAddrs map[netip.Addr]struct{}
+}
+
+var (
+	/* Blocklist given to the client, consulted on every peer connection */
+	peerFilter = &ipFilter{}
+)
+
+// Lookup is called by the client for each peer address, recording the refused ones
+func (f *ipFilter) Lookup(ip net.IP) (iplist.Range, bool) {
+	r, ok := f.lookup(ip)
+	if addr, aok := netip.AddrFromSlice(ip); ok && aok {
+		f.blockedMu.Lock()
+		if f.blockedAddrs == nil {
+			f.blockedAddrs = map[netip.Addr]struct{}{}
+		}
+		f.blockedAddrs[addr.Unmap()] = struct{}{}
+		f.blockedMu.Unlock()
+	}
+	return r, ok
+}
+
+// Returns the count of distinct peer IPs refused since startup
+func (f *ipFilter) blockedConnections() int64 {
+	f.blockedMu.Lock()
+	defer f.blockedMu.Unlock()
+	return int64(len(f.blockedAddrs))
+}
+
+// Returns the banned or blocklisted range of the IP
+func (f *ipFilter) lookup(ip net.IP) (iplist.Range, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return iplist.Range{}, false
+	}
+	addr = addr.Unmap()
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, ban := range f.bans {
+		if ban.Contains(addr) {
+			return iplist.Range{
+				First:       ban.Masked().Addr().AsSlice(),
+				Last:        lastPrefixAddr(ban).AsSlice(),
+				Description: "banned",
+			}, true
+		}
+	}
+	if f.list != nil {
+		return f.list.Lookup(ip)
+	}
+	return iplist.Range{}, false
+}
+
+func (f *ipFilter) NumRanges() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.list != nil {
+		return len(f.bans) + f.list.NumRanges()
+	}
+	return len(f.bans)
+}
+
+// Replaces the ranges of the blocklist file
+func (f *ipFilter) setList(list iplist.Ranger) {
+	f.mu.Lock()
+	f.list = list
+	f.mu.Unlock()
+}
+
+// Replaces the banned ranges
+func (f *ipFilter) setBans(bans []netip.Prefix) {
+	f.mu.Lock()
+	f.bans = bans
+	f.mu.Unlock()
+}
+
+// Check if the address of a peer is blocked
+func (f *ipFilter) blocked(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	_, blocked := f.lookup(net.ParseIP(host))
+	return blocked
+}
+
+// Returns the last address of a prefix
+func lastPrefixAddr(p netip.Prefix) netip.Addr {
+	b := p.Masked().Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// Parses an IP or CIDR range, single IPs becoming full length prefixes
+func parseBan(ban string) (netip.Prefix, error) {
+	ban = strings.TrimSpace(ban)
+	if strings.Contains(ban, "/") {
+		p, err := netip.ParsePrefix(ban)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(p.Addr().Unmap(), p.Bits()).Masked(), nil
+	}
+	addr, err := netip.ParseAddr(ban)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Formats a banned range, single IPs being given without prefix length
+func formatBan(p netip.Prefix) string {
+	if p.IsSingleIP() {
+		return p.Addr().String()
+	}
+	return p.String()
+}
+
+// Loads the persistent ban list into the client blocklist
+func loadBans() error {
+	bans, err := getBans()
+	if err != nil {
+		return err
+	}
+
+	prefixes := []netip.Prefix{}
+	for _, ban := range bans {
+		p, perr := parseBan(ban)
+		if perr != nil {
+			Warn.Printf("Cannot load ban \"%s\": %s\n", ban, perr)
+			continue
+		}
+		prefixes = append(prefixes, p)
+	}
+	peerFilter.setBans(prefixes)
+	return nil
+}
+
+// Closes the connections of the peers now blocked
+func disconnectBlockedPeers() {
+	for _, th := range btEngine.torrentHandles() {
+		for _, pc := range th.Torrent.PeerConns() {
+			if peerFilter.blocked(pc.RemoteAddr.String()) {
+				pc.Close()
+			}
+		}
+	}
+}
+
+// Creates the response of the ban list
+func createBansRes() apiBansRes {
+	peerFilter.mu.RLock()
+	defer peerFilter.mu.RUnlock()
+
+	res := apiBansRes{Bans: []string{}}
+	for _, ban := range peerFilter.bans {
+		res.Bans = append(res.Bans, formatBan(ban))
+	}
+	return res
+}
+
+// Parses the IPs and ranges of a ban request body
+func decodeBansBody(w http.ResponseWriter, r *http.Request) ([]netip.Prefix, bool) {
+	body := apiBansBody{}
+	if decodeBody(w, r.Body, &body) != nil {
+		return nil, false
+	}
+	if len(body.IPs) < 1 {
+		errorRes(w, "No IPs provided", http.StatusBadRequest)
+		return nil, false
+	}
+
+	prefixes := []netip.Prefix{}
+	for _, ip := range body.IPs {
+		p, err := parseBan(ip)
+		if err != nil {
+			errorResDetails(w, "Invalid IP or range", err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, true
+}
+
+// Endpoint listing the banned IPs and ranges
+func apiBans(w http.ResponseWriter, r *http.Request) {
+	res := createBansRes()
+	encodeRes(w, &res)
+}
+
+// Endpoint banning IPs and ranges, disconnecting the peers within them
+func apiAddBans(w http.ResponseWriter, r *http.Request) {
+	prefixes, ok := decodeBansBody(w, r)
+	if !ok {
+		return
+	}
+
+	/* Persist the new bans, saving a ban again leaves it unchanged */
+	added := []string{}
+	for _, p := range prefixes {
+		added = append(added, p.String())
+	}
+	if err := updateBans(added, nil); err != nil {
+		errorResDetails(w, "Cannot save bans", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	/* Apply them to the client, merged under the lock so concurrent changes are kept */
+	peerFilter.mu.Lock()
+	bans := slices.Clone(peerFilter.bans)
+	for _, p := range prefixes {
+		if !slices.Contains(bans, p) {
+			bans = append(bans, p)
+		}
+	}
+	peerFilter.bans = bans
+	peerFilter.mu.Unlock()
+	disconnectBlockedPeers()
+
+	res := createBansRes()
+	encodeRes(w, &res)
+}
+
+// Endpoint lifting bans of IPs and ranges
+func apiRemoveBans(w http.ResponseWriter, r *http.Request) {
+	prefixes, ok := decodeBansBody(w, r)
+	if !ok {
+		return
+	}
+
+	removed := []string{}
+	for _, p := range prefixes {
+		removed = append(removed, p.String())
+	}
+	if err := updateBans(nil, removed); err != nil {
+		errorResDetails(w, "Cannot save bans", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	peerFilter.mu.Lock()
+	peerFilter.bans = slices.DeleteFunc(slices.Clone(peerFilter.bans), func(p netip.Prefix) bool {
+		return slices.Contains(prefixes, p)
+	})
+	peerFilter.mu.Unlock()
+
+	res := createBansRes()
+	encodeRes(w, &res)
+}
+
+// Endpoint adding peer addresses to a torrent, kept in its persistent spec
+func apiAddPeers(w http.ResponseWriter, r *http.Request) {
+	t, err := btEngine.getTorrHandle(mux.Vars(r)["infohash"])
+	if err != nil {
+		torrHandleErrorRes(w, err)
+		return
+	}
+
+	body := apiPeersBody{}
+	if decodeBody(w, r.Body, &body) != nil {
+		return
+	}
+	if len(body.Peers) < 1 {
+		errorRes(w, "No peers provided", http.StatusBadRequest)
+		return
+	}
+
+	/* Peers are IP and port pairs */
+	peers := []torrent.PeerInfo{}
+	addrs := []string{}
+	for _, peer := range body.Peers {
+		addr, perr := netip.ParseAddrPort(strings.TrimSpace(peer))
+		if perr != nil {
+			errorResDetails(w, "Invalid peer address", perr.Error(), http.StatusBadRequest)
+			return
+		}
+		if peerFilter.blocked(addr.String()) {
+			errorRes(w, "Peer is banned: "+addr.String(), http.StatusConflict)
+			return
+		}
+		peers = append(peers, torrent.PeerInfo{
+			Addr:    net.TCPAddrFromAddrPort(addr),
+			Source:  torrent.PeerSourceDirect,
+			Trusted: true,
+		})
+		addrs = append(addrs, addr.String())
+	}
+
+	/* Persist them to be added again on restart, before connecting so a failure changes nothing */
+	res := apiPeersRes{InfoHash: t.InfoHash().String()}
+	uperr := updateSpec(res.InfoHash, func(spec *persistentSpec) {
+		for _, addr := range addrs {
+			if !slices.Contains(spec.PeerAddrs, addr) {
+				spec.PeerAddrs = append(spec.PeerAddrs, addr)
+			}
+		}
+		res.Peers = spec.PeerAddrs
+	})
+	if errors.Is(uperr, errSpecNotFound) {
+		errorRes(w, "Cannot save peers of a torrent without spec", http.StatusConflict)
+		return
+	}
+	if uperr != nil {
+		errorResDetails(w, "Cannot save peers", uperr.Error(), http.StatusInternalServerError)
+		return
+	}
+	t.AddPeers(peers)
+	encodeRes(w, &res)
+}
+
+// Endpoint disconnecting a peer from a torrent
+func apiDisconnectPeer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	t, err := btEngine.getTorrHandle(vars["infohash"])
+	if err != nil {
+		torrHandleErrorRes(w, err)
+		return
+	}
+
+	for _, pc := range t.PeerConns() {
+		if pc.RemoteAddr.String() == vars["peer"] {
+			pc.Close()
+			res := apiDisconnectPeerRes{
+				InfoHash: t.InfoHash().String(),
+				Peer:     vars["peer"],
+			}
+			encodeRes(w, &res)
+			return
+		}
+	}
+	errorRes(w, "Peer not found", http.StatusNotFound)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Bans added concurrently are all applied
+func TestAddBansConcurrent(t *testing.T) {
+	newTestTorrent(t, map[string]string{"a.txt": "a"})
+	if err := createBanBucket(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peerFilter.setBans(nil) })
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			serveAPI(http.MethodPost, "/api/bans", strings.NewReader(`{"ips":["`+ip+`"]}`))
+		}("10.0.0." + strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	peerFilter.mu.RLock()
+	defer peerFilter.mu.RUnlock()
+	if len(peerFilter.bans) != 10 {
+		t.Errorf("Bans %v", peerFilter.bans)
+	}
+}
+
+// Peers of a torrent without spec are refused before connecting
+func TestAddPeersWithoutSpec(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{"a.txt": "a"})
+	w := serveAPI(http.MethodPost, "/api/torrents/"+tt.InfoHash().String()+"/peers", strings.NewReader(`{"peers":["192.0.2.1:6881"]}`))
+	if !strings.Contains(w.Body.String(), "without spec") {
+		t.Errorf("Response %s", w.Body)
+	}
+	if n := tt.Stats().PendingPeers; n != 0 {
+		t.Errorf("%d peers added", n)
+	}
+}
+
+// A refused peer is counted once however often it is looked up
+func TestBlockedConnectionsDistinct(t *testing.T) {
+	filter := &ipFilter{bans: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	for i := 0; i < 3; i++ {
+		filter.Lookup(net.ParseIP("10.0.0.1"))
+		filter.Lookup(net.ParseIP("192.0.2.1"))
+	}
+	filter.Lookup(net.ParseIP("10.0.0.2"))
+	if n := filter.blockedConnections(); n != 2 {
+		t.Errorf("%d blocked connections", n)
+	}
+}
@@ -0,0 +1,217 @@
+/* Contains the loading of IP blocklists in eMule DAT or PeerGuardian P2P format */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/torrent/iplist"
+)
+
+// Blocked address range
+type ipRange struct {
+	First       netip.Addr
+	Last        netip.Addr
+	Description string
+}
+
+// Sorted and merged blocked ranges, IPv4 and IPv6 addresses never being compared together
+type ipRangeList []ipRange
+
+var (
+	/* Path of the blocklist file, empty if none is used */
+	blocklistPath string
+
+	/* Matches the lines of eMule DAT blocklists as first - last , level , description */
+	datLineRegexp = regexp.MustCompile(`^\s*([0-9.]+)\s*-\s*([0-9.]+)\s*,\s*([0-9]+)\s*(?:,(.*))?$`)
+)
+
+func (l ipRangeList) Lookup(ip net.IP) (iplist.Range, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return iplist.Range{}, false
+	}
+	addr = addr.Unmap()
+
+	/* Last range starting at or before the address */
+	i := sort.Search(len(l), func(i int) bool {
+		return addr.Less(l[i].First)
+	}) - 1
+	if i < 0 || l[i].First.Is4() != addr.Is4() || l[i].Last.Less(addr) {
+		return iplist.Range{}, false
+	}
+	return iplist.Range{
+		First:       l[i].First.AsSlice(),
+		Last:        l[i].Last.AsSlice(),
+		Description: l[i].Description,
+	}, true
+}
+
+func (l ipRangeList) NumRanges() int {
+	return len(l)
+}
+
+// Loads the blocklist file into the client blocklist
+func initBlocklist(path string) {
+	if path == "" {
+		return
+	}
+	blocklistPath = path
+
+	n, err := reloadBlocklist()
+	if err != nil {
+		Warn.Printf("IP blocklist is not loaded: %s\n", err)
+		return
+	}
+	Info.Printf("IP blocklist is loaded with %d ranges\n", n)
+}
+
+// Reads the blocklist file again, returning its number of ranges
+func reloadBlocklist() (int, error) {
+	if blocklistPath == "" {
+		return 0, errors.New("no blocklist file is set")
+	}
+
+	list, err := loadBlocklist(blocklistPath)
+	if err != nil {
+		return 0, err
+	}
+	peerFilter.setList(list)
+	disconnectBlockedPeers()
+	return list.NumRanges(), nil
+}
+
+// Parses a blocklist file in DAT or P2P format, gzipped or not
+func loadBlocklist(path string) (ipRangeList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	/* Gzipped files are detected from their magic number */
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, gzerr := gzip.NewReader(br)
+		if gzerr != nil {
+			return nil, gzerr
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	ranges := ipRangeList{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		rng, ok := parseBlocklistLine(scanner.Text())
+		if ok {
+			ranges = append(ranges, rng)
+		}
+	}
+	if serr := scanner.Err(); serr != nil {
+		return nil, serr
+	}
+	if len(ranges) == 0 {
+		return nil, errors.New("no IP range found")
+	}
+
+	/* Overlapping ranges are merged for the search by first address */
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].First.Less(ranges[j].First)
+	})
+	merged := ipRangeList{ranges[0]}
+	for _, rng := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if rng.First.Is4() == last.Last.Is4() && !last.Last.Less(rng.First) {
+			if last.Last.Less(rng.Last) {
+				last.Last = rng.Last
+			}
+			continue
+		}
+		merged = append(merged, rng)
+	}
+	return merged, nil
+}
+
+// Parses a DAT or P2P line, comments and malformed lines being skipped
+func parseBlocklistLine(line string) (ipRange, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+		return ipRange{}, false
+	}
+
+	/* DAT ranges with an access level above 127 are allowed */
+	if m := datLineRegexp.FindStringSubmatch(line); m != nil {
+		level, _ := strconv.Atoi(m[3])
+		first, ferr := parseZeroPaddedIPv4(m[1])
+		last, lerr := parseZeroPaddedIPv4(m[2])
+		if level > 127 || ferr != nil || lerr != nil || last.Less(first) {
+			return ipRange{}, false
+		}
+		return ipRange{First: first, Last: last, Description: strings.TrimSpace(m[4])}, true
+	}
+
+	rng, ok, err := iplist.ParseBlocklistP2PLine([]byte(line))
+	if err != nil || !ok {
+		return ipRange{}, false
+	}
+	first, _ := netip.AddrFromSlice(rng.First)
+	last, _ := netip.AddrFromSlice(rng.Last)
+	first, last = first.Unmap(), last.Unmap()
+	if first.Is4() != last.Is4() || last.Less(first) {
+		return ipRange{}, false
+	}
+	return ipRange{First: first, Last: last, Description: rng.Description}, true
+}
+
+// Parses an IPv4 address whose parts may be zero padded as in DAT files
+func parseZeroPaddedIPv4(s string) (netip.Addr, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
+		return netip.Addr{}, errors.New("invalid IPv4 address")
+	}
+	var ip [4]byte
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 8)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		ip[i] = byte(n)
+	}
+	return netip.AddrFrom4(ip), nil
+}
+
+// Creates the response of the blocklist
+func createBlocklistRes(ranges int) apiBlocklistRes {
+	return apiBlocklistRes{
+		File:               blocklistPath,
+		Ranges:             ranges,
+		BlockedConnections: peerFilter.blockedConnections(),
+	}
+}
+
+// Endpoint reading the blocklist file again
+func apiReloadBlocklist(w http.ResponseWriter, r *http.Request) {
+	n, err := reloadBlocklist()
+	if err != nil {
+		errorResDetails(w, "Cannot load blocklist", err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	Info.Printf("IP blocklist is reloaded with %d ranges\n", n)
+
+	res := createBlocklistRes(n)
+	encodeRes(w, &res)
+}
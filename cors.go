@@ -0,0 +1,53 @@
+/* Contains the CORS policy of the HTTP server */
+
+package main
+
+import (
+	"strings"
+
+	"github.com/rs/cors"
+)
+
+// Creates the CORS middleware from comma separated lists of origins, methods and headers
+func newCorsPolicy(origins string, methods string, headers string, credentials bool) *cors.Cors {
+	allowedOrigins := splitList(origins)
+
+	/* Credentials must not be shared with any origin */
+	for _, origin := range allowedOrigins {
+		if origin == "*" && credentials {
+			Warn.Println("CORS credentials are disabled as all origins are allowed")
+			credentials = false
+			break
+		}
+	}
+
+	if len(allowedOrigins) == 0 {
+		Info.Println("CORS is disabled for all origins")
+	} else {
+		Info.Printf("CORS allowed origins: %s\n", strings.Join(allowedOrigins, ", "))
+	}
+
+	opts := cors.Options{
+		AllowedOrigins:   allowedOrigins,
+		AllowedMethods:   splitList(methods),
+		AllowedHeaders:   splitList(headers),
+		AllowCredentials: credentials,
+	}
+	/* An empty list would allow every origin */
+	if len(allowedOrigins) == 0 {
+		opts.AllowOriginFunc = func(origin string) bool { return false }
+	}
+	return cors.New(opts)
+}
+
+// Splits a comma separated list, dropping empty entries
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// No origin is allowed when the list is empty
+func TestCorsNoOrigins(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, origins := range []string{"", "https://allowed.example"} {
+		h := newCorsPolicy(origins, "GET", "Content-Type", false).Handler(ok)
+		r := httptest.NewRequest(http.MethodGet, "/api/torrents", nil)
+		r.Header.Set("Origin", "https://other.example")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("Origins %q allow %q", origins, got)
+		}
+	}
+}
@@ -3,8 +3,8 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"path/filepath"
 	"strings"
 	"time"
@@ -38,7 +38,7 @@ func createSpecBucket() error {
 }
 
 // Saves torrent spec to database file
-func saveSpec(spec *torrent.TorrentSpec) error {
+func saveSpec(spec *torrent.TorrentSpec, noDefaultTrackers bool) error {
 	/* Marshal torrent spec to JSON persistentSpec */
 	json, err := json.Marshal(persistentSpec{
 		Trackers:                 spec.Trackers,
@@ -51,6 +51,8 @@ func saveSpec(spec *torrent.TorrentSpec) error {
 		DisableInitialPieceCheck: spec.DisableInitialPieceCheck,
 		DisallowDataUpload:       spec.DisallowDataUpload,
 		DisallowDataDownload:     spec.DisallowDataDownload,
+		NoDefaultTrackers:        noDefaultTrackers,
+		AddedAt:                  time.Now(),
 	})
 	if err != nil {
 		return err
@@ -86,7 +88,7 @@ func loadPersist() {
 	/* Iterates over all specs */
 	for _, spec := range specs {
 		/* Add spec to BitTorrent client */
-		t, terr := btEngine.addTorrent(persistSpecToTorrentSpec(spec), true)
+		t, terr := btEngine.addTorrent(context.Background(), persistSpecToTorrentSpec(spec), true, spec.NoDefaultTrackers)
 		if terr != nil {
 			Warn.Printf("Cannot load spec \"%s\": %s\n", spec.InfoHash, terr)
 			rmerr := removeSpec(spec.InfoHash)
@@ -96,6 +98,30 @@ func loadPersist() {
 			continue
 		}
 
+		th, ok := btEngine.torrentHandle(t.InfoHash().String())
+		if !ok {
+			continue
+		}
+
+		/* Restore the time the torrent was first added */
+		if spec.AddedAt.IsZero() {
+			spec.AddedAt = th.AddedAt
+			uperr := updateSpec(spec.InfoHash, func(s *persistentSpec) {
+				s.AddedAt = spec.AddedAt
+			})
+			if uperr != nil {
+				Warn.Printf("Cannot update spec \"%s\": %s\n", spec.InfoHash, uperr)
+			}
+		}
+		th.AddedAt = spec.AddedAt
+		th.CompletedAt = spec.CompletedAt
+		th.Labels = spec.Labels
+		th.PrevUlDataBytes = spec.UploadedBytes
+		th.SavedUlDataBytes = spec.UploadedBytes
+		if spec.DisplayNameEdited {
+			th.DisplayName = spec.DisplayName
+		}
+
 		/* Start download of files in persistent spec */
 		for _, f := range spec.Files {
 			tf, tferr := getTorrentFile(t, f.File)
@@ -149,7 +175,7 @@ func getSpec(infohash string) (persistentSpec, error) {
 			return spec, nil
 		}
 	}
-	return persistentSpec{}, errors.New("torrent spec not found")
+	return persistentSpec{}, errSpecNotFound
 }
 
 func removeSpec(infohash string) error {
@@ -169,26 +195,102 @@ func removeSpec(infohash string) error {
 
 // Adds file of torrent to DB for persistence
 func saveSpecFile(infohash string, filename string, filepriority torrent.PiecePriority) error {
-	/* Get persistence spec from infohash */
-	spec, err := getSpec(infohash)
-	if err != nil {
-		return err
+	return updateSpec(infohash, func(spec *persistentSpec) {
+		spec.Files = append(spec.Files, persistentSpecFiles{
+			File:     filename,
+			Priority: filepriority,
+		})
+	})
+}
+
+// Modifies the persistentSpec of infohash in DB
+func updateSpec(infohash string, update func(spec *persistentSpec)) error {
+	/* Opens DB file */
+	db, dberr := openDB()
+	if dberr != nil {
+		return dberr
 	}
+	defer db.Close()
+
+	/* Reads, modifies and writes the spec in one transaction so concurrent updates are not lost */
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("TorrSpecs"))
+		key := []byte(strings.ToLower(infohash))
+		v := b.Get(key)
+		if v == nil {
+			return errSpecNotFound
+		}
 
-	/* Remove unmodified spec */
-	rmerr := removeSpec(infohash)
-	if rmerr != nil {
-		return rmerr
+		spec := persistentSpec{}
+		if err := json.Unmarshal(v, &spec); err != nil {
+			return err
+		}
+		update(&spec)
+		json, jerr := json.Marshal(&spec)
+		if jerr != nil {
+			return jerr
+		}
+		return b.Put(key, json)
+	})
+}
+
+func createBanBucket() error {
+	/* Opens DB file */
+	db, dberr := openDB()
+	if dberr != nil {
+		return dberr
 	}
+	defer db.Close()
 
-	/* Create new spec with file */
-	spec.Files = append(spec.Files, persistentSpecFiles{
-		File:     filename,
-		Priority: filepriority,
+	/* Create Bans bucket */
+	return db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("Bans"))
+		return err
 	})
-	json, jerr := json.Marshal(&spec)
-	if jerr != nil {
-		return jerr
+}
+
+// Get all banned IP ranges from DB
+func getBans() ([]string, error) {
+	/* Opens DB file */
+	db, dberr := openDB()
+	if dberr != nil {
+		return nil, dberr
 	}
-	return specToDB(infohash, json)
+	defer db.Close()
+
+	/* Ranges are the keys of the bucket */
+	bans := []string{}
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("Bans"))
+		return b.ForEach(func(k, v []byte) error {
+			bans = append(bans, string(k))
+			return nil
+		})
+	})
+	return bans, err
+}
+
+// Adds or removes banned IP ranges in DB
+func updateBans(add []string, remove []string) error {
+	/* Opens DB file */
+	db, dberr := openDB()
+	if dberr != nil {
+		return dberr
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("Bans"))
+		for _, ban := range add {
+			if err := b.Put([]byte(ban), []byte{}); err != nil {
+				return err
+			}
+		}
+		for _, ban := range remove {
+			if err := b.Delete([]byte(ban)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
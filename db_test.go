@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Concurrent updates of a spec are all kept
+func TestUpdateSpecConcurrent(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{"a.txt": "a"})
+	ih := tt.InfoHash().String()
+	if err := saveSpec(&torrent.TorrentSpec{InfoHash: tt.InfoHash()}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(label string) {
+			defer wg.Done()
+			if err := updateSpec(ih, func(s *persistentSpec) { s.Labels = append(s.Labels, label) }); err != nil {
+				t.Error(err)
+			}
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	spec, err := getSpec(ih)
+	if err != nil || len(spec.Labels) != 10 {
+		t.Errorf("Labels %v, error %v", spec.Labels, err)
+	}
+}
+
+// Updating a missing spec is an error
+func TestUpdateSpecNotFound(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{"a.txt": "a"})
+	if err := updateSpec(tt.InfoHash().String(), func(s *persistentSpec) {}); err != errSpecNotFound {
+		t.Errorf("Error %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+/* Contains the default tracker list appended to the trackers of every torrent */
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+)
+
+var (
+	/* Path of the default tracker list file, empty if none is used */
+	defaultTrackersPath string
+
+	/* Trackers of the list file */
+	defaultTrackers   []string
+	defaultTrackersMu sync.RWMutex
+)
+
+// Loads the default tracker list file
+func initDefaultTrackers(path string) {
+	if path == "" {
+		return
+	}
+	defaultTrackersPath = path
+
+	n, err := reloadDefaultTrackers()
+	if err != nil {
+		Warn.Printf("Default trackers are not loaded: %s\n", err)
+		return
+	}
+	Info.Printf("Default trackers are loaded with %d trackers\n", n)
+}
+
+// Reads the default tracker list file again, adding the new trackers to the torrents
+func reloadDefaultTrackers() (int, error) {
+	if defaultTrackersPath == "" {
+		return 0, errors.New("no default tracker file is set")
+	}
+
+	trackers, err := loadDefaultTrackers(defaultTrackersPath)
+	if err != nil {
+		return 0, err
+	}
+	defaultTrackersMu.Lock()
+	defaultTrackers = trackers
+	defaultTrackersMu.Unlock()
+
+	for _, th := range btEngine.torrentHandles() {
+		if !th.NoDefaultTrackers {
+			added := missingDefaultTrackers(torrentTrackerTiers(th.Torrent))
+			addTorrentTrackers(th.Torrent, added, nil)
+			th.DefaultTrackersMu.Lock()
+			th.DefaultTrackers = append(th.DefaultTrackers, added...)
+			th.DefaultTrackersMu.Unlock()
+		}
+	}
+	return len(trackers), nil
+}
+
+// Parses a tracker list file of one URL per line, blank lines and comments being skipped
+func loadDefaultTrackers(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	trackers := []string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || slices.Contains(trackers, line) {
+			continue
+		}
+		u, uerr := url.Parse(line)
+		if uerr != nil {
+			return nil, fmt.Errorf("line %d: %w", n, uerr)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("line %d: not a tracker URL: %s", n, line)
+		}
+		trackers = append(trackers, line)
+	}
+	return trackers, scanner.Err()
+}
+
+// Returns the default trackers
+func getDefaultTrackers() []string {
+	defaultTrackersMu.RLock()
+	defer defaultTrackersMu.RUnlock()
+	return slices.Clone(defaultTrackers)
+}
+
+// Returns the default trackers missing from the tiers
+func missingDefaultTrackers(tiers [][]string) []string {
+	missing := []string{}
+	for _, tr := range getDefaultTrackers() {
+		if !hasTracker(tiers, tr) {
+			missing = append(missing, tr)
+		}
+	}
+	return missing
+}
+
+// Appends the trackers to the tiers, each in a new tier
+func appendTrackerTiers(tiers [][]string, trackers []string) [][]string {
+	tiers = slices.Clone(tiers)
+	for _, tr := range trackers {
+		tiers = append(tiers, []string{tr})
+	}
+	return tiers
+}
+
+// Check if a tracker of a torrent was added from the default list
+func isTorrentDefaultTracker(t *torrent.Torrent, tracker string) bool {
+	th, ok := btEngine.torrentHandle(t.InfoHash().String())
+	if !ok {
+		return false
+	}
+	th.DefaultTrackersMu.Lock()
+	defer th.DefaultTrackersMu.Unlock()
+	return slices.Contains(th.DefaultTrackers, tracker)
+}
+
+// Removes the trackers added from the default list from the tiers, empty tiers being dropped
+func withoutDefaultTrackers(t *torrent.Torrent, tiers [][]string) [][]string {
+	own := [][]string{}
+	for _, tier := range tiers {
+		tier = slices.DeleteFunc(slices.Clone(tier), func(tr string) bool {
+			return isTorrentDefaultTracker(t, tr)
+		})
+		if len(tier) > 0 {
+			own = append(own, tier)
+		}
+	}
+	return own
+}
+
+// Makes trackers given by the user their own, so they are saved even if they are in the default list
+func ownTrackers(t *torrent.Torrent, trackers []string) {
+	if th, ok := btEngine.torrentHandle(t.InfoHash().String()); ok {
+		th.DefaultTrackersMu.Lock()
+		th.DefaultTrackers = slices.DeleteFunc(th.DefaultTrackers, func(tr string) bool {
+			return slices.Contains(trackers, tr)
+		})
+		th.DefaultTrackersMu.Unlock()
+	}
+}
+
+// Creates the response of the default trackers
+func createDefaultTrackersRes() apiDefaultTrackersRes {
+	return apiDefaultTrackersRes{
+		File:     defaultTrackersPath,
+		Trackers: getDefaultTrackers(),
+	}
+}
+
+// Endpoint listing the default trackers
+func apiDefaultTrackers(w http.ResponseWriter, r *http.Request) {
+	res := createDefaultTrackersRes()
+	encodeRes(w, &res)
+}
+
+// Endpoint reading the default tracker list file again
+func apiReloadDefaultTrackers(w http.ResponseWriter, r *http.Request) {
+	n, err := reloadDefaultTrackers()
+	if err != nil {
+		errorResDetails(w, "Cannot load default trackers", err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	Info.Printf("Default trackers are reloaded with %d trackers\n", n)
+
+	res := createDefaultTrackersRes()
+	encodeRes(w, &res)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Lines of the list must be tracker URLs
+func TestLoadDefaultTrackers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trackers.txt")
+	os.WriteFile(path, []byte("# list\nudp://tracker.example:1337/announce\n\nudp://tracker.example:1337/announce\n"), 0644)
+	trackers, err := loadDefaultTrackers(path)
+	if err != nil || !reflect.DeepEqual(trackers, []string{"udp://tracker.example:1337/announce"}) {
+		t.Errorf("Trackers %v, error %v", trackers, err)
+	}
+
+	os.WriteFile(path, []byte("udp://tracker.example:1337/announce\ntracker.example\n"), 0644)
+	if _, err := loadDefaultTrackers(path); err == nil {
+		t.Errorf("Line without scheme is accepted")
+	}
+}
+
+// Only the trackers added from the list are left out of the spec, and only new torrents get them
+func TestDefaultTrackersOrigin(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{"a.txt": "a"})
+	def, own := "http://127.0.0.1:1/default", "http://127.0.0.1:1/own"
+	defaultTrackers = []string{def}
+	t.Cleanup(func() { defaultTrackers = nil })
+
+	/* Adding the torrent again does not add the list */
+	if _, err := btEngine.addTorrent(context.Background(), &torrent.TorrentSpec{InfoHash: tt.InfoHash()}, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if tiers := torrentTrackerTiers(tt); len(tiers) != 0 {
+		t.Errorf("Default trackers added to an existing torrent: %v", tiers)
+	}
+
+	tt.AddTrackers([][]string{{def}, {own}})
+	btEngine.Torrents[tt.InfoHash().String()].DefaultTrackers = []string{def}
+	if tiers := withoutDefaultTrackers(tt, torrentTrackerTiers(tt)); !reflect.DeepEqual(tiers, [][]string{{own}}) {
+		t.Errorf("Saved tiers %v", tiers)
+	}
+
+	/* A default tracker given by the user is saved */
+	ownTrackers(tt, []string{def})
+	if tiers := withoutDefaultTrackers(tt, torrentTrackerTiers(tt)); !reflect.DeepEqual(tiers, [][]string{{def}, {own}}) {
+		t.Errorf("Saved tiers %v after owning the default tracker", tiers)
+	}
+}
+
+// Torrent handles and their default trackers can be used while torrents are added and removed
+func TestTorrentHandlesConcurrent(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{"a.txt": "a"})
+	tr := "http://127.0.0.1:1/default"
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			btEngine.removeTorrentHandle(tt.InfoHash().String())
+			btEngine.addTorrentHandle(tt, &torrent.TorrentSpec{InfoHash: tt.InfoHash()})
+		}()
+		go func() {
+			defer wg.Done()
+			if th, ok := btEngine.torrentHandle(tt.InfoHash().String()); ok {
+				th.DefaultTrackersMu.Lock()
+				th.DefaultTrackers = append(th.DefaultTrackers, tr)
+				th.DefaultTrackersMu.Unlock()
+			}
+			ownTrackers(tt, []string{tr})
+		}()
+		go func() {
+			defer wg.Done()
+			isTorrentDefaultTracker(tt, tr)
+			torrentName(tt)
+		}()
+	}
+	wg.Wait()
+}
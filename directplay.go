@@ -0,0 +1,277 @@
+/* Contains the torrent sources of direct play */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	/* Maximum size of a fetched or uploaded torrent file */
+	maxTorrentFileSize = 10 << 20
+	/* Time direct play waits for the info of a torrent resolved from peers */
+	torrentInfoTimeout = 2 * time.Minute
+)
+
+var (
+	/* HTTP client fetching torrent files from URLs, only connecting to public addresses */
+	torrentFetchClient = &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 10 * time.Second,
+				Control: publicAddressOnly,
+			}).DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+)
+
+// Dialer control refusing connections to loopback, private and other non-public addresses
+func publicAddressOnly(network string, address string, c syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	addr := addrPort.Addr().Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return errors.New("address is not public: " + addr.String())
+	}
+	return nil
+}
+
+// Gets the torrent spec of direct play from a magnet link, infohash, torrent URL or uploaded torrent file
+func directPlaySpec(w http.ResponseWriter, r *http.Request) (*torrent.TorrentSpec, bool) {
+	query := r.URL.Query()
+	displayName := query.Get("dn")
+	trackers, trackersOk := query["tr"]
+
+	var spec *torrent.TorrentSpec
+	switch {
+	// Uploaded torrent file
+	case r.Method == http.MethodPost:
+		torrfile, _, err := r.FormFile("torrent")
+		if err != nil {
+			errorResDetails(w, "Torrent file upload error", err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		defer torrfile.Close()
+
+		var specErr error
+		spec, specErr = torrentSpecFromReader(torrfile)
+		if specErr != nil {
+			errorResDetails(w, "Loading torrent file error", specErr.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+
+	// Torrent file URL
+	case query.Get("torrent") != "":
+		var fetchErr error
+		spec, fetchErr = fetchTorrentSpec(query.Get("torrent"))
+		if fetchErr != nil {
+			errorResDetails(w, "Fetching torrent file error", fetchErr.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+
+	// Magnet link
+	case query.Get("magnet") != "":
+		// Parses magnet link to add display name and trackers from query
+		parsedMagnet, parseMagnetErr := metainfo.ParseMagnetUri(query.Get("magnet"))
+		if parseMagnetErr != nil {
+			errorRes(w, "Parsing magnet link error", http.StatusBadRequest)
+			return nil, false
+		}
+		if displayName != "" {
+			parsedMagnet.DisplayName = displayName
+		}
+		if trackersOk {
+			parsedMagnet.Trackers = trackers
+		}
+
+		// Parse magnet link to torrent spec
+		var specErr error
+		spec, specErr = torrent.TorrentSpecFromMagnetUri(parsedMagnet.String())
+		if specErr != nil {
+			errorRes(w, "Creating torrent spec error", http.StatusBadRequest)
+			return nil, false
+		}
+		return spec, true
+
+	// Bare infohash resolved through DHT
+	case query.Get("infohash") != "":
+		infohash := query.Get("infohash")
+		var ih metainfo.Hash
+		if len(infohash) != 40 || ih.FromHexString(infohash) != nil {
+			errorRes(w, errInvalidInfoHash.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		return makeTorrentSpec(infohash, displayName, trackers), true
+
+	default:
+		errorRes(w, "No magnet link, infohash or torrent file provided", http.StatusBadRequest)
+		return nil, false
+	}
+
+	/* Display name and trackers from query apply to torrent files too */
+	if displayName != "" {
+		spec.DisplayName = displayName
+	}
+	for _, tracker := range trackers {
+		spec.Trackers = append(spec.Trackers, []string{tracker})
+	}
+	return spec, true
+}
+
+// Fetches a torrent file from an HTTP(S) URL
+func fetchTorrentSpec(rawURL string) (*torrent.TorrentSpec, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("unsupported URL scheme: " + u.Scheme)
+	}
+
+	res, err := torrentFetchClient.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected status: " + res.Status)
+	}
+	return torrentSpecFromReader(res.Body)
+}
+
+// Loads a torrent file as torrent spec, refusing files over maxTorrentFileSize
+func torrentSpecFromReader(r io.Reader) (*torrent.TorrentSpec, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxTorrentFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxTorrentFileSize {
+		return nil, errors.New("torrent file is too large")
+	}
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return torrent.TorrentSpecFromMetaInfoErr(mi)
+}
+
+// Adds the torrent of direct play, waiting a bounded time for its info
+func addDirectPlayTorrent(w http.ResponseWriter, r *http.Request, spec *torrent.TorrentSpec) (*torrent.Torrent, bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), torrentInfoTimeout)
+	defer cancel()
+
+	t, err := btEngine.addTorrent(ctx, spec, false, false)
+	if errors.Is(err, errTorrentInfoTimeout) {
+		errorRes(w, "Torrent info was not received in time", http.StatusGatewayTimeout)
+		return nil, false
+	}
+	if err != nil {
+		errorResDetails(w, "Adding torrent error", err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return t, true
+}
+
+// Selects a file of the torrent by index, name regexp or the largest video
+func selectPlayFile(t *torrent.Torrent, index string, match string) (*torrent.File, error) {
+	files := t.Files()
+
+	/* By index in the torrent */
+	if index != "" {
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 || i >= len(files) {
+			return nil, errors.New("invalid file index")
+		}
+		return files[i], nil
+	}
+
+	/* First file matching the regexp in natural order */
+	if match != "" {
+		re, err := regexp.Compile(match)
+		if err != nil {
+			return nil, err
+		}
+		sorted := append([]*torrent.File{}, files...)
+		sortFilesNatural(sorted)
+		for _, f := range sorted {
+			if re.MatchString(f.DisplayPath()) {
+				return f, nil
+			}
+		}
+		return nil, errors.New("no file matches")
+	}
+
+	/* Largest video file */
+	var largest *torrent.File
+	for _, f := range files {
+		if isVideoFile(f.DisplayPath()) && (largest == nil || f.Length() > largest.Length()) {
+			largest = f
+		}
+	}
+	if largest == nil {
+		return nil, errors.New("no video file found")
+	}
+	return largest, nil
+}
+
+// Endpoint redirecting to the stream of a single file for players without playlist support
+func apiPlayFile(w http.ResponseWriter, r *http.Request) {
+	/* Check the file selectors before adding the torrent */
+	query := r.URL.Query()
+	if query.Get("select") != "" && query.Get("select") != "largest" {
+		errorRes(w, "Unknown file selector: "+query.Get("select"), http.StatusBadRequest)
+		return
+	}
+	if _, reerr := regexp.Compile(query.Get("match")); reerr != nil {
+		errorResDetails(w, "Invalid file match", reerr.Error(), http.StatusBadRequest)
+		return
+	}
+
+	/* Get the torrent spec from the given source */
+	spec, ok := directPlaySpec(w, r)
+	if !ok {
+		return
+	}
+
+	// Add torrent spec to BT engine
+	t, ok := addDirectPlayTorrent(w, r, spec)
+	if !ok {
+		return
+	}
+
+	/* Select the file, defaulting to the largest video */
+	f, ferr := selectPlayFile(t, query.Get("index"), query.Get("match"))
+	if ferr != nil {
+		errorResDetails(w, "File selection error", ferr.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Starts download of the selected file
+	f.SetPriority(torrent.PiecePriorityNormal)
+	saveSpecFile(t.InfoHash().String(), f.DisplayPath(), f.Priority())
+
+	/* Redirect to the signed stream link so the API key is not exposed to the player */
+	link := createFileLink(t.InfoHash().String(), torrentFileIndex(f), false)
+	if authEnabled {
+		link = signLink(link, signedLinkTTL)
+	}
+	http.Redirect(w, r, requestBaseURL(r)+link, http.StatusFound)
+}
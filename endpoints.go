@@ -1,8 +1,9 @@
 package main
 
 import (
+	"mime"
 	"net/http"
-	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,7 +29,7 @@ func apiAddTorrent(w http.ResponseWriter, r *http.Request) {
 		var err error
 		spec, err = torrent.TorrentSpecFromMagnetUri(body.Magnet)
 		if err != nil {
-			errorRes(w, "Magnet decoding error: "+err.Error(), http.StatusInternalServerError)
+			errorResDetails(w, "Magnet decoding error", err.Error(), http.StatusBadRequest)
 			return
 		}
 	}
@@ -39,14 +40,14 @@ func apiAddTorrent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if spec == nil {
-		errorRes(w, "No torrent provided", http.StatusNotFound)
+		errorRes(w, "No torrent provided", http.StatusBadRequest)
 		return
 	}
 
 	var terr error
-	t, terr = btEngine.addTorrent(spec, false)
+	t, terr = btEngine.addTorrent(r.Context(), spec, false, body.NoDefaultTrackers)
 	if terr != nil {
-		errorRes(w, "Torrent add error: "+terr.Error(), http.StatusInternalServerError)
+		errorResDetails(w, "Torrent add error", terr.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -66,49 +67,49 @@ func apiTorrentSelectFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	/* Check if no provided files */
-	if !body.AllFiles && len(body.Files) < 1 {
-		errorRes(w, "No files provided", http.StatusNotFound)
+	if !body.AllFiles && len(body.Files) < 1 && len(body.Indexes) < 1 {
+		errorRes(w, "No files provided", http.StatusBadRequest)
 		return
 	}
 
 	/* Gets torrent handler from client */
 	t, err := btEngine.getTorrHandle(body.InfoHash)
 	if err != nil {
-		errorRes(w, err.Error(), http.StatusInternalServerError)
+		torrHandleErrorRes(w, err)
 		return
 	}
 
+	// Files selected by DisplayPath are resolved to their index
+	selected := selectedFileIndexes(t, body.Files, body.Indexes)
+
 	/* Create the response body */
 	res.InfoHash = t.InfoHash().String()
-	res.Name = t.Name()
+	res.Name = torrentName(t)
 
 	/* Initiate download for selected files */
 
 	// If AllFiles is toggled
 	if body.AllFiles {
-		// Empties the Files slice to prevent the execution of the code below when AllFiles if toggled
-		body.Files = nil
+		// Empties the selected files to prevent the execution of the code below when AllFiles if toggled
+		selected = nil
 
 		// Starts download for all files in the torrent
 		/* Go through the selected files to append its info to the response */
-		for _, f := range t.Files() {
+		for i, f := range t.Files() {
 			f.SetPriority(torrent.PiecePriorityNormal)
 			saveSpecFile(t.InfoHash().String(), f.DisplayPath(), f.Priority())
 			res.Files = append(res.Files, apiTorrentSelectFileResFiles{
+				Index:    i,
 				FileName: f.DisplayPath(),
-				Stream:   createFileLink(t.InfoHash().String(), f.DisplayPath(), false),
-				Download: createFileLink(t.InfoHash().String(), f.DisplayPath(), true),
+				Stream:   createFileLink(t.InfoHash().String(), i, false),
+				Download: createFileLink(t.InfoHash().String(), i, true),
 			})
 		}
 	}
 
 	// If specific files are selected
-	for _, f := range body.Files {
-		/* Get the handle of the torrent file from its DisplayPath */
-		tf, tferr := getTorrentFile(t, f)
-		if tferr != nil {
-			continue
-		}
+	for _, i := range selected {
+		tf := t.Files()[i]
 
 		// Starts download of said torrent file
 		tf.SetPriority(torrent.PiecePriorityNormal)
@@ -118,9 +119,10 @@ func apiTorrentSelectFile(w http.ResponseWriter, r *http.Request) {
 
 		/* Go through the selected files to append its info to the response */
 		res.Files = append(res.Files, apiTorrentSelectFileResFiles{
+			Index:    i,
 			FileName: tf.DisplayPath(),
-			Stream:   createFileLink(t.InfoHash().String(), tf.DisplayPath(), false),
-			Download: createFileLink(t.InfoHash().String(), tf.DisplayPath(), true),
+			Stream:   createFileLink(t.InfoHash().String(), i, false),
+			Download: createFileLink(t.InfoHash().String(), i, true),
 		})
 	}
 
@@ -138,21 +140,24 @@ func apiTorrentPriorityFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	/* Check if no provided files */
-	if !body.AllFiles && len(body.Files) < 1 {
-		errorRes(w, "No files provided", http.StatusNotFound)
+	if !body.AllFiles && len(body.Files) < 1 && len(body.Indexes) < 1 {
+		errorRes(w, "No files provided", http.StatusBadRequest)
 		return
 	}
 
 	/* Gets torrent handler from client */
 	t, err := btEngine.getTorrHandle(body.InfoHash)
 	if err != nil {
-		errorRes(w, err.Error(), http.StatusInternalServerError)
+		torrHandleErrorRes(w, err)
 		return
 	}
 
+	// Files selected by DisplayPath are resolved to their index
+	selected := selectedFileIndexes(t, body.Files, body.Indexes)
+
 	/* Create the response body */
 	res.InfoHash = t.InfoHash().String()
-	res.Name = t.Name()
+	res.Name = torrentName(t)
 	res.Priority = body.Priority
 
 	// Parse the priority from the request body
@@ -172,11 +177,11 @@ func apiTorrentPriorityFile(w http.ResponseWriter, r *http.Request) {
 
 	// If AllFiles is toggled
 	if body.AllFiles {
-		// Empties the Files slice to prevent the execution of the code below when AllFiles if toggled
-		body.Files = nil
+		// Empties the selected files to prevent the execution of the code below when AllFiles if toggled
+		selected = nil
 
 		/* Go through the selected files to append its info to the response */
-		for _, f := range t.Files() {
+		for i, f := range t.Files() {
 
 			// Set priority of said torrent file to none essentially disabling its download
 			f.SetPriority(selectedPriority)
@@ -186,18 +191,15 @@ func apiTorrentPriorityFile(w http.ResponseWriter, r *http.Request) {
 
 			/* Go through the all files to append its info to the response */
 			res.Files = append(res.Files, apiTorrentPriorityFileResFiles{
+				Index:    i,
 				FileName: f.DisplayPath(),
 			})
 		}
 	}
 
 	// If specific files are selected
-	for _, f := range body.Files {
-		/* Get the handle of the torrent file from its DisplayPath */
-		tf, tferr := getTorrentFile(t, f)
-		if tferr != nil {
-			continue
-		}
+	for _, i := range selected {
+		tf := t.Files()[i]
 
 		// Set priority of said torrent file to none essentially disabling its download
 		tf.SetPriority(selectedPriority)
@@ -207,6 +209,7 @@ func apiTorrentPriorityFile(w http.ResponseWriter, r *http.Request) {
 
 		/* Go through the selected files to append its info to the response */
 		res.Files = append(res.Files, apiTorrentPriorityFileResFiles{
+			Index:    i,
 			FileName: tf.DisplayPath(),
 		})
 	}
@@ -216,37 +219,28 @@ func apiTorrentPriorityFile(w http.ResponseWriter, r *http.Request) {
 
 // Endpoint for streaming a file
 func apiStreamTorrentFile(w http.ResponseWriter, r *http.Request) {
-	// Get infohash and filename variables
-	vars := mux.Vars(r)
-
-	/* Get torrent handle from infohash */
-	t, err := btEngine.getTorrHandle(vars["infohash"])
-	if err != nil {
-		errorRes(w, err.Error(), http.StatusNotFound)
-		return
-	}
-
-	/* Unescape given filename */
-	fn, fnerr := url.QueryUnescape(vars["file"])
-	if fnerr != nil {
-		errorRes(w, "Filename unescaping error: "+fnerr.Error(), http.StatusInternalServerError)
+	/* Get torrent and file handles from infohash and file index or filename */
+	t, f, ok := requestTorrentFile(w, r)
+	if !ok {
 		return
 	}
 
-	/* Get torrent file handle from filename */
-	f, ferr := getTorrentFile(t, fn)
-	if ferr != nil {
-		errorRes(w, ferr.Error(), http.StatusNotFound)
+	/* Track the client as a stream session, ended after its reader is closed */
+	session, serr := startStreamSession(r, t, f)
+	if serr != nil {
+		errorResDetails(w, "Stream session error", serr.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer endStreamSession(session)
 
 	/* Make torrent file reader for streaming */
-	reader := f.NewReader()
+	// The buffer adapts to the playback bitrate and download speed
+	reader := newStreamReader(f)
 	defer reader.Close()
-	// Set the buffer to 1% of the file size
-	reader.SetReadahead(f.Length() / 100)
+	reader.Session = session
+
 	// Send the reader as HTTP response
-	http.ServeContent(w, r, f.DisplayPath(), time.Now(), reader)
+	serveTorrentFile(w, r, t, f, reader)
 }
 
 // Endpoint for removing a torrent
@@ -260,18 +254,18 @@ func apiRemoveTorrent(w http.ResponseWriter, r *http.Request) {
 	/* Getting the torrent handle */
 	t, terr := btEngine.getTorrHandle(body.InfoHash)
 	if terr != nil {
-		errorRes(w, terr.Error(), http.StatusNotFound)
+		torrHandleErrorRes(w, terr)
 		return
 	}
 
 	/* Saving of variables for response body */
-	tname := t.Name()
+	tname := torrentName(t)
 	ih := t.InfoHash().String()
 
 	/* Remover function */
 	rmerr := btEngine.dropTorrent(ih, body.RemoveFiles)
 	if rmerr != nil {
-		errorRes(w, "Torrent removal error: "+rmerr.Error(), http.StatusInternalServerError)
+		errorResDetails(w, "Torrent removal error", rmerr.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -290,126 +284,185 @@ func apiTorrentStats(w http.ResponseWriter, r *http.Request) {
 	res := apiTorrentStasRes{}
 
 	/* Variables */
-	tlist := btEngine.Torrents
+	tlist := btEngine.torrentHandles()
 	ih := vars["infohash"]
+	humanized := r.URL.Query().Get("humanize") != "false"
+
+	/* Parse the filters, sort key, page and fields */
+	query, qerr := parseTorrentQuery(r)
+	if qerr != nil {
+		errorResDetails(w, "Invalid query", qerr.Error(), http.StatusBadRequest)
+		return
+	}
 
 	/* If provided with infohash */
 	if ih != "" {
 		/* Check if infohash is valid */
 		_, terr := btEngine.getTorrHandle(ih)
 		if terr != nil {
-			errorRes(w, terr.Error(), http.StatusNotFound)
+			torrHandleErrorRes(w, terr)
 			return
 		}
 
 		/* Overwrite tlist with only the selected torrent's handle */
-		templist := make(map[string]*torrentHandle)
-		templist[ih] = btEngine.Torrents[ih]
-		tlist = templist
+		th, ok := btEngine.torrentHandle(ih)
+		if !ok {
+			errorRes(w, "Torrent info is not available yet", http.StatusConflict)
+			return
+		}
+		tlist = []*torrentHandle{th}
 	}
 
 	/* Go through the tlist */
 	for _, v := range tlist {
-		tstats := apiTorrentStasResTorrents{}
-
-		/* Setting main stats */
-		tstats.Name = v.Torrent.Name()
-		tstats.InfoHash = v.Torrent.InfoHash().String()
-		tstats.TotalPeers = v.Torrent.Stats().TotalPeers
-		tstats.ActivePeers = v.Torrent.Stats().ActivePeers
-		tstats.PendingPeers = v.Torrent.Stats().PendingPeers
-		tstats.HalfOpenPeers = v.Torrent.Stats().HalfOpenPeers
+		tstats := createTorrentStatsRes(v, humanized, query.hasField("peers"), query.hasField("files"))
+
+		/* Append it response body if it matches the filters */
+		if query.match(tstats) {
+			res.Torrents = append(res.Torrents, tstats)
+		}
+	}
+
+	/* Sort and paginate the matching torrents */
+	res.Total = len(res.Torrents)
+	res.BlockedConnections = peerFilter.blockedConnections()
+	query.sort(res.Torrents)
+	res.Torrents = query.page(res.Torrents)
+
+	/* Send response with only the selected fields */
+	if len(query.Fields) > 0 {
+		sres, serr := selectTorrentFields(res, query.Fields)
+		if serr != nil {
+			errorResDetails(w, "JSON Encoder error", serr.Error(), http.StatusInternalServerError)
+			return
+		}
+		encodeRes(w, &sres)
+		return
+	}
+	encodeRes(w, &res)
+}
+
+// Creates the stats of a torrent, peers and files being costly on large torrents
+func createTorrentStatsRes(v *torrentHandle, humanized bool, withPeers bool, withFiles bool) apiTorrentStasResTorrents {
+	tstats := apiTorrentStasResTorrents{}
+
+	/* Setting main stats */
+	tstats.Name = torrentName(v.Torrent)
+	tstats.Labels = append([]string{}, v.Labels...)
+	tstats.InfoHash = v.Torrent.InfoHash().String()
+	tstats.TotalPeers = v.Torrent.Stats().TotalPeers
+	tstats.ActivePeers = v.Torrent.Stats().ActivePeers
+	tstats.PendingPeers = v.Torrent.Stats().PendingPeers
+	tstats.HalfOpenPeers = v.Torrent.Stats().HalfOpenPeers
+	if humanized {
 		tstats.DownloadSpeed = v.DlSpeedReadable
 		tstats.UploadSpeed = v.UlSpeedReadable
 		tstats.Progress = calcTorrentProgress(v.Torrent)
+	}
+	if v.Torrent.Info() != nil {
+		tstats.ProgressFraction = progressFraction(v.Torrent.BytesCompleted(), v.Torrent.Length())
+		tstats.SizeBytes = v.Torrent.Length()
+		tstats.DownloadedBytes = v.Torrent.BytesCompleted()
+	}
+	tstats.UploadedBytes = v.uploadedBytes()
+	tstats.DownloadSpeedBytes = v.DlSpeedBytes
+	tstats.UploadSpeedBytes = v.UlSpeedBytes
+	if tstats.DownloadedBytes > 0 {
+		tstats.Ratio = float64(tstats.UploadedBytes) / float64(tstats.DownloadedBytes)
+	}
+	tstats.AddedAt = v.AddedAt
+	if !v.CompletedAt.IsZero() {
+		completedAt := v.CompletedAt
+		tstats.Completed = true
+		tstats.CompletedAt = &completedAt
+		/* The client also uploads while downloading */
+		tstats.Seeding = v.Torrent.Seeding()
+		if tstats.Seeding {
+			tstats.SeedingTime = int64(time.Since(completedAt).Seconds())
+		}
+	}
+	tstats.PieceMap = torrentPieceMap(v.Torrent)
+	tstats.ETA = calcTorrentETA(v)
 
-		/* Setting the peers info */
+	/* Setting the peers info */
+	if withPeers {
 		for _, peer := range v.Torrent.PeerConns() {
-			paddr := peer.Peer.RemoteAddr.String()
-			pcli, ok := peer.PeerClientName.Load().(string)
-			if !ok {
-				pcli = "NOTPROVIDED"
-			}
-
-			tstats.Peers = append(tstats.Peers, apiTorrentStatsPeersInfo{
-				PeerAddr:   paddr,
-				PeerClient: pcli,
-			})
+			tstats.Peers = append(tstats.Peers, createPeerStatsRes(v.Torrent, peer))
 		}
+	}
 
-		/* Setting the files available in the torrent */
-		for _, tf := range v.Torrent.Files() {
+	/* Setting the files available in the torrent */
+	if withFiles {
+		subIndex := newSubtitleIndex(v.Torrent)
+		for i, tf := range v.Torrent.Files() {
 			tfname := tf.DisplayPath()
 			tfbc := tf.BytesCompleted()
 			tflen := tf.Length()
 			curf := apiTorrentStatsTorrentsFiles{
-				FileName:           tfname,
-				FileSizeBytes:      int(tflen),
-				FileSizeReadable:   humanize.Bytes(uint64(tflen)),
-				DownloadedBytes:    int(tfbc),
-				DownloadedReadable: humanize.Bytes(uint64(tfbc)),
-				Priority:           torrentPriorityToString(tf.Priority()),
+				Index:            i,
+				FileName:         tfname,
+				FileSizeBytes:    int(tflen),
+				DownloadedBytes:  int(tfbc),
+				ProgressFraction: progressFraction(tfbc, tflen),
+				Completed:        tfbc == tflen,
+				Priority:         torrentPriorityToString(tf.Priority()),
+			}
+			if humanized {
+				curf.FileSizeReadable = humanize.Bytes(uint64(tflen))
+				curf.DownloadedReadable = humanize.Bytes(uint64(tfbc))
 			}
 			if tf.BytesCompleted() > 0 {
-				curf.Stream = createFileLink(tstats.InfoHash, tfname, false)
-				curf.Download = createFileLink(tstats.InfoHash, tfname, true)
+				curf.Stream = createFileLink(tstats.InfoHash, i, false)
+				curf.Download = createFileLink(tstats.InfoHash, i, true)
 			}
+			curf.Subtitles = createSubtitlesRes(subIndex, tf)
+			curf.CompletedRanges = fileCompletedRanges(tf)
 			tstats.Files = append(tstats.Files, curf)
 		}
-
-		/* Append it response body */
-		res.Torrents = append(res.Torrents, tstats)
 	}
 
-	/* Send response */
-	encodeRes(w, &res)
+	return tstats
 }
 
 func apiDownloadFile(w http.ResponseWriter, r *http.Request) {
-	/* Get infohash and filename vars*/
-	vars := mux.Vars(r)
-
-	/* Get torrent handle from infohash */
-	t, err := btEngine.getTorrHandle(vars["infohash"])
-	if err != nil {
-		errorRes(w, err.Error(), http.StatusNotFound)
-		return
-	}
-
-	/* Unescape given filename */
-	fn, fnerr := url.QueryUnescape(vars["file"])
-	if fnerr != nil {
-		errorRes(w, "Filename unescaping error: "+fnerr.Error(), http.StatusInternalServerError)
+	/* Get torrent and file handles from infohash and file index or filename */
+	t, f, ok := requestTorrentFile(w, r)
+	if !ok {
 		return
 	}
 
-	/* Get torrent file handle from filename */
-	f, ferr := getTorrentFile(t, fn)
-	if ferr != nil {
-		errorRes(w, ferr.Error(), http.StatusNotFound)
-		return
-	}
-
-	/* Check if file is finished downloading */
+	/* Check if file is finished downloading, serving its completed ranges only if asked */
 	if f.BytesCompleted() != f.Length() {
-		errorRes(w, "File is not completed", http.StatusAccepted)
+		if r.URL.Query().Get("partial") == "true" {
+			servePartialFile(w, r, t, f)
+			return
+		}
+		errorRes(w, "File is not completed", http.StatusConflict)
 		return
 	}
 
-	/* Set Content-Disposition as f.DisplayPath() */
-	w.Header().Add("Content-Disposition", "attachment; filename=\""+safenDisplayPath(f.DisplayPath())+"\"")
-
 	/* Send file as response */
+	setAttachment(w, f)
 	reader := f.NewReader()
 	defer reader.Close()
-	http.ServeContent(w, r, f.DisplayPath(), time.Now(), reader)
+	serveTorrentFile(w, r, t, f, reader)
+}
+
+// Set Content-Disposition as f.DisplayPath()
+func setAttachment(w http.ResponseWriter, f *torrent.File) {
+	setAttachmentName(w, safenDisplayPath(f.DisplayPath()))
+}
+
+// Set Content-Disposition with the file name quoted or encoded as needed
+func setAttachmentName(w http.ResponseWriter, name string) {
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
 }
 
 func apiAddTorrentFile(w http.ResponseWriter, r *http.Request) {
 	/* Gets file from form */
 	torrfile, _, err := r.FormFile("torrent")
 	if err != nil {
-		errorRes(w, err.Error(), http.StatusInternalServerError)
+		errorRes(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	defer torrfile.Close()
@@ -418,17 +471,17 @@ func apiAddTorrentFile(w http.ResponseWriter, r *http.Request) {
 	/* Loads the torrent file as a MetaInfo */
 	mi, mierr := metainfo.Load(torrfile)
 	if mierr != nil {
-		errorRes(w, mierr.Error(), http.StatusInternalServerError)
+		errorRes(w, mierr.Error(), http.StatusBadRequest)
 		return
 	}
 	/* Makes torrent spec from given MetaInfo */
 	spec, specerr := torrent.TorrentSpecFromMetaInfoErr(mi)
 	if specerr != nil {
-		errorRes(w, specerr.Error(), http.StatusInternalServerError)
+		errorRes(w, specerr.Error(), http.StatusBadRequest)
 		return
 	}
 	/* Adds torrent spec to the BitTorrent client */
-	t, terr := btEngine.addTorrent(spec, false)
+	t, terr := btEngine.addTorrent(r.Context(), spec, false, r.FormValue("nodefaulttrackers") == "true")
 	if terr != nil {
 		errorRes(w, terr.Error(), http.StatusInternalServerError)
 		return
@@ -439,73 +492,78 @@ func apiAddTorrentFile(w http.ResponseWriter, r *http.Request) {
 	encodeRes(w, &res)
 }
 
-// Make playlist for direct streaming of magnet link or torrent files
+// Make playlist for direct streaming of magnet link, infohash or torrent files
 func apiDirectPlay(w http.ResponseWriter, r *http.Request) {
-	/* Parse magnet link from query */
-	magnet := r.URL.Query().Get("magnet")
-	displayName := r.URL.Query().Get("dn")
-	trackers, trackersOk := r.URL.Query()["tr"]
-	files, filesOk := r.URL.Query()["file"]
-
-	// Check if magnet link is present
-	if magnet == "" {
-		errorRes(w, "Invalid magnet link", http.StatusBadRequest)
+	/* The format is checked before the torrent is touched */
+	format := r.URL.Query().Get("format")
+	if ferr := checkPlaylistFormat(format); ferr != nil {
+		errorRes(w, ferr.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Parses magnet link to add display name and trackers from query
-	parsedMagnet, parseMagnetErr := metainfo.ParseMagnetUri(magnet)
-	if parseMagnetErr != nil {
-		errorRes(w, "Parsing magnet link error", http.StatusInternalServerError)
-		return
-	}
-	if displayName != "" {
-		parsedMagnet.DisplayName = displayName
-	}
-	if trackersOk {
-		parsedMagnet.Trackers = trackers
+	files, filesOk := r.URL.Query()["file"]
+	indexes := []int{}
+	for _, index := range r.URL.Query()["index"] {
+		i, ierr := strconv.Atoi(index)
+		if ierr != nil {
+			errorRes(w, "Invalid file index", http.StatusBadRequest)
+			return
+		}
+		indexes = append(indexes, i)
 	}
+	filesOk = filesOk || len(indexes) > 0
 
-	// Parse magnet link to torrent spec
-	spec, specErr := torrent.TorrentSpecFromMagnetUri(parsedMagnet.String())
-	if specErr != nil {
-		errorRes(w, "Creating torrent spec error", http.StatusInternalServerError)
+	/* Get the torrent spec from the given source */
+	spec, ok := directPlaySpec(w, r)
+	if !ok {
 		return
 	}
 
 	// Add torrent spec to BT engine
-	t, addTorrentErr := btEngine.addTorrent(spec, false)
-	if addTorrentErr != nil {
-		errorRes(w, "Adding torrent error", http.StatusInternalServerError)
+	t, ok := addDirectPlayTorrent(w, r, spec)
+	if !ok {
 		return
 	}
 
-	/* Create the playlist file with the selected files */
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+t.InfoHash().String()+".m3u\"")
-	playList := "#EXTM3U\n"
-
-	httpScheme := "http"
-	if r.Header.Get("X-Forwarded-Proto") != "" {
-		httpScheme = r.Header.Get("X-Forwarded-Proto")
-	}
+	baseURL := requestBaseURL(r)
 
+	/* Select the requested files, or the media files of the torrent */
+	selected := []*torrent.File{}
 	if !filesOk {
+		mediaKinds := []string{"video", "audio"}
+		if media := r.URL.Query().Get("media"); media != "" {
+			mediaKinds = strings.Split(media, ",")
+		}
 		for _, file := range t.Files() {
-			file.SetPriority(torrent.PiecePriorityNormal)
-			saveSpecFile(t.InfoHash().String(), file.DisplayPath(), file.Priority())
-			playList += appendFilePlaylist(httpScheme, r.Host, t.InfoHash().String(), file.DisplayPath())
+			if matchMediaKinds(file.DisplayPath(), mediaKinds) {
+				selected = append(selected, file)
+			}
 		}
+		sortFilesNatural(selected)
 	}
 
-	for _, file := range files {
-		torrentFile, getTorretnFileErr := getTorrentFile(t, file)
-		if getTorretnFileErr != nil {
-			continue
-		}
-		torrentFile.SetPriority(torrent.PiecePriorityNormal)
-		saveSpecFile(t.InfoHash().String(), torrentFile.DisplayPath(), torrentFile.Priority())
-		playList += appendFilePlaylist(httpScheme, r.Host, t.InfoHash().String(), torrentFile.DisplayPath())
+	// Files selected by DisplayPath and by index
+	for _, i := range selectedFileIndexes(t, files, indexes) {
+		selected = append(selected, t.Files()[i])
 	}
 
-	w.Write([]byte(playList))
+	/* Start download of the selected files and create their playlist entries */
+	signed := authEnabled && r.URL.Query().Get("signed") == "true"
+	entries := []playlistEntry{}
+	subIndex := newSubtitleIndex(t)
+	for _, file := range selected {
+		file.SetPriority(torrent.PiecePriorityNormal)
+		saveSpecFile(t.InfoHash().String(), file.DisplayPath(), file.Priority())
+		entries = append(entries, newPlaylistEntry(baseURL, subIndex, file, signed))
+	}
+
+	/* Create the playlist file in the requested format */
+	playList, contentType, ext, playListErr := renderPlaylist(format, torrentName(t), entries)
+	if playListErr != nil {
+		errorRes(w, playListErr.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	setAttachmentName(w, t.InfoHash().String()+ext)
+	w.Write(playList)
 }
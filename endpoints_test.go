@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Files named like an index must not be shadowed by the index routes
+func TestFileByIndexAndName(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{
+		"a.txt":   "first",
+		"index/1": "named like an index",
+	})
+	ih := tt.InfoHash().String()
+
+	w := serveAPI(http.MethodGet, "/api/file/"+ih+"/index/1", nil)
+	if w.Body.String() != "named like an index" {
+		t.Errorf("File by name is %q", w.Body)
+	}
+
+	for i, f := range tt.Files() {
+		w = serveAPI(http.MethodGet, "/api/file/index/"+ih+"/"+strconv.Itoa(i), nil)
+		if w.Body.String() != map[string]string{"a.txt": "first", "index/1": "named like an index"}[f.DisplayPath()] {
+			t.Errorf("File by index %d is %q", i, w.Body)
+		}
+	}
+}
+
+// Links of files with characters changed by query escaping serve the file
+func TestFileLinksEscapedNames(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{"a+b 100%.txt": "content"})
+	ih := tt.InfoHash().String()
+
+	w := serveAPI(http.MethodGet, "/api/torrents/"+ih, nil)
+	res := apiTorrentStasRes{}
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil || len(res.Torrents) != 1 || len(res.Torrents[0].Files) != 1 {
+		t.Fatalf("Stats %+v, error %v", res, err)
+	}
+	for _, link := range []string{res.Torrents[0].Files[0].Stream, res.Torrents[0].Files[0].Download} {
+		w = serveAPI(http.MethodGet, link, nil)
+		if w.Body.String() != "content" {
+			t.Errorf("Link %s served %q", link, w.Body)
+		}
+	}
+}
+
+// Errors of incomplete files are not sent as attachments
+func TestDownloadIncompleteFile(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{
+		"done.txt":    strings.Repeat("d", 16<<10),
+		"missing.txt": strings.Repeat("m", 16<<10),
+	}, "missing.txt")
+	ih := tt.InfoHash().String()
+
+	w := serveAPI(http.MethodGet, "/api/file/"+ih+"/missing.txt", nil)
+	if w.Header().Get("Content-Disposition") != "" || !strings.Contains(w.Body.String(), "File is not completed") {
+		t.Errorf("Incomplete file response %q with Content-Disposition %q", w.Body, w.Header().Get("Content-Disposition"))
+	}
+
+	w = serveAPI(http.MethodGet, "/api/file/"+ih+"/done.txt", nil)
+	if w.Header().Get("Content-Disposition") == "" {
+		t.Errorf("Completed file is not an attachment")
+	}
+}
+
+// Torrents without a spec are not edited
+func TestEditTorrentWithoutSpec(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{"a.txt": "a"})
+	ih := tt.InfoHash().String()
+
+	w := serveAPI(http.MethodPatch, "/api/torrents/"+ih, strings.NewReader(`{"displayname":"Edited"}`))
+	if !strings.Contains(w.Body.String(), "without spec") || torrentName(tt) == "Edited" {
+		t.Errorf("Name %q after editing, response %s", torrentName(tt), w.Body)
+	}
+}
+
+// Edited names and webseed removals are kept in the spec
+func TestEditTorrentPersisted(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{"a.txt": "a"})
+	ih := tt.InfoHash().String()
+	if err := saveSpec(&torrent.TorrentSpec{InfoHash: tt.InfoHash(), Webseeds: []string{"http://127.0.0.1:1/"}}, false); err != nil {
+		t.Fatal(err)
+	}
+	btEngine.Torrents[ih].Webseeds = []string{"http://127.0.0.1:1/"}
+
+	w := serveAPI(http.MethodPatch, "/api/torrents/"+ih, strings.NewReader(`{"displayname":"Edited","webseeds":[]}`))
+	spec, err := getSpec(ih)
+	if err != nil || spec.DisplayName != "Edited" || !spec.DisplayNameEdited || len(spec.Webseeds) != 0 {
+		t.Errorf("Spec %+v, error %v, response %s", spec, err, w.Body)
+	}
+
+	serveAPI(http.MethodPatch, "/api/torrents/"+ih, strings.NewReader(`{"displayname":""}`))
+	if spec, _ = getSpec(ih); spec.DisplayNameEdited || torrentName(tt) != tt.Name() {
+		t.Errorf("Spec %+v and name %q after restoring the name", spec, torrentName(tt))
+	}
+}
+
+// Quotes and non-ASCII names do not break the Content-Disposition of archives
+func TestArchiveDispositionName(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{"a\"b é/x.txt": "x"})
+	w := serveAPI(http.MethodGet, "/api/archive/"+tt.InfoHash().String()+"?folder="+url.QueryEscape("a\"b é"), nil)
+	_, params, err := mime.ParseMediaType(w.Header().Get("Content-Disposition"))
+	if err != nil || params["filename"] != "test - a\"b é.zip" {
+		t.Errorf("Content-Disposition %q, error %v", w.Header().Get("Content-Disposition"), err)
+	}
+}
@@ -3,7 +3,7 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"os"
 	"path/filepath"
 	"time"
@@ -13,6 +13,9 @@ import (
 	"github.com/dustin/go-humanize"
 )
 
+// Interval between saves of the uploaded bytes of torrents
+const uploadSaveInterval = time.Minute
+
 // Creates the BitTorrent client
 func (Engine *btEng) initialize(opts *torrent.ClientConfig) {
 	// Saves the given config to the Engine
@@ -31,13 +34,22 @@ func (Engine *btEng) initialize(opts *torrent.ClientConfig) {
 		Warn.Println("Upload is disabled")
 	}
 
-	/* Initialize custom torrent map and speed calculator */
+	/* Initialize custom torrent map, speed calculator and tracker scrapes */
 	Engine.Torrents = make(map[string]*torrentHandle)
 	go btEngine.calculateSpeeds()
+	go scrapeTrackers()
 }
 
-// Add torrent to client
-func (Engine *btEng) addTorrent(spec *torrent.TorrentSpec, noSave bool) (*torrent.Torrent, error) {
+// Add torrent to client, with the default trackers unless noDefaultTrackers, waiting for its info until ctx is done
+func (Engine *btEng) addTorrent(ctx context.Context, spec *torrent.TorrentSpec, noSave bool, noDefaultTrackers bool) (*torrent.Torrent, error) {
+	/* The default trackers are only added to new torrents and not saved to follow the changes of the list */
+	saved := *spec
+	defaults := []string{}
+	if _, exists := Engine.Client.Torrent(spec.InfoHash); !exists && !noDefaultTrackers {
+		defaults = missingDefaultTrackers(spec.Trackers)
+		spec.Trackers = appendTrackerTiers(spec.Trackers, defaults)
+	}
+
 	/* Adds spec to BitTorrent client */
 	t, new, err := Engine.Client.AddTorrentSpec(spec)
 	if err != nil {
@@ -46,18 +58,38 @@ func (Engine *btEng) addTorrent(spec *torrent.TorrentSpec, noSave bool) (*torren
 
 	/* Check if torrent is new then save its spec for persistence */
 	if new && !noSave {
-		sserr := saveSpec(spec)
+		sserr := saveSpec(&saved, noDefaultTrackers)
 		if sserr != nil {
 			return nil, sserr
 		}
 	}
 
-	// Wait for torrent info
-	<-t.GotInfo()
-
-	// Adds spec to custom torrent handler
-	Engine.addTorrentHandle(t, spec)
+	/* Adds spec to custom torrent handler once the info is received, even if the caller stopped waiting */
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		select {
+		case <-t.GotInfo():
+		case <-t.Closed():
+			return
+		}
+		Engine.addTorrentHandle(t, spec)
+		if th, ok := Engine.torrentHandle(t.InfoHash().String()); ok && new {
+			th.NoDefaultTrackers = noDefaultTrackers
+			th.DefaultTrackersMu.Lock()
+			th.DefaultTrackers = defaults
+			th.DefaultTrackersMu.Unlock()
+		}
+	}()
 
+	select {
+	case <-handled:
+	case <-ctx.Done():
+		return nil, errTorrentInfoTimeout
+	}
+	if t.Info() == nil {
+		return nil, errTorrentNotFound
+	}
 	return t, nil
 }
 
@@ -65,13 +97,13 @@ func (Engine *btEng) addTorrent(spec *torrent.TorrentSpec, noSave bool) (*torren
 func (Engine *btEng) getTorrHandle(infohash string) (*torrent.Torrent, error) {
 	/* Checks if infohash is 40 characters */
 	if len(infohash) != 40 {
-		return nil, errors.New("invalid infohash")
+		return nil, errInvalidInfoHash
 	}
 
 	/* Get torrent handle */
 	t, ok := Engine.Client.Torrent(metainfo.NewHashFromHex(infohash))
 	if !ok {
-		return nil, errors.New("torrent not found")
+		return nil, errTorrentNotFound
 	}
 	return t, nil
 }
@@ -87,6 +119,7 @@ func (Engine *btEng) dropTorrent(infohash string, rmfiles bool) error {
 	/* Remove torrent handles */
 	Engine.removeTorrentHandle(infohash)
 	t.Drop()
+	forgetTrackerScrapes(t.InfoHash().String(), nil)
 
 	/* Removes torrent persistence spec */
 	rmerr := removeSpec(t.InfoHash().String())
@@ -103,41 +136,131 @@ func (Engine *btEng) dropTorrent(infohash string, rmfiles bool) error {
 
 // Adds torrent handle to custom torrent handler
 func (Engine *btEng) addTorrentHandle(t *torrent.Torrent, spec *torrent.TorrentSpec) {
+	Engine.TorrentsMu.Lock()
+	defer Engine.TorrentsMu.Unlock()
+
+	/* Keep the stats of torrents added again */
+	if th, ok := Engine.Torrents[t.InfoHash().String()]; ok {
+		th.Spec = spec
+		return
+	}
+
 	Engine.Torrents[t.InfoHash().String()] = &torrentHandle{
-		Torrent: t,
-		Spec:    spec,
+		Torrent:              t,
+		Spec:                 spec,
+		AddedAt:              time.Now(),
+		Webseeds:             spec.Webseeds,
+		DisallowDataUpload:   spec.DisallowDataUpload,
+		DisallowDataDownload: spec.DisallowDataDownload,
 	}
 }
 
 // Remove torrent handle from custom torrent handle
 func (Engine *btEng) removeTorrentHandle(infohash string) {
+	Engine.TorrentsMu.Lock()
 	delete(Engine.Torrents, infohash)
+	Engine.TorrentsMu.Unlock()
+}
+
+// Returns the torrent handle of infohash, safe to use while torrents are added or removed
+func (Engine *btEng) torrentHandle(infohash string) (*torrentHandle, bool) {
+	Engine.TorrentsMu.RLock()
+	defer Engine.TorrentsMu.RUnlock()
+	th, ok := Engine.Torrents[infohash]
+	return th, ok
+}
+
+// Returns the current torrent handles, safe to use while torrents are added or removed
+func (Engine *btEng) torrentHandles() []*torrentHandle {
+	Engine.TorrentsMu.RLock()
+	defer Engine.TorrentsMu.RUnlock()
+	handles := make([]*torrentHandle, 0, len(Engine.Torrents))
+	for _, th := range Engine.Torrents {
+		handles = append(handles, th)
+	}
+	return handles
 }
 
 func (Engine *btEng) calculateSpeeds() {
-	torrents := Engine.Torrents
 	interval := time.Second
+	lastSave := time.Now()
 
 	for {
-		for k := range torrents {
+		for _, th := range Engine.torrentHandles() {
+			th.trackCompletion()
+
 			/*
 				Work-around for the oddity cause by atomics
 				See: https://github.com/anacrolix/torrent/issues/745
 			*/
-			curstats := torrents[k].Torrent.Stats()
+			curstats := th.Torrent.Stats()
+			th.LastUlDataBytes = curstats.BytesWrittenData.Int64()
 
 			/* Download speed */
 			dlcurprog := curstats.BytesRead.Int64()
-			torrents[k].DlSpeedBytes = (int64(interval) * (dlcurprog - torrents[k].LastDlBytes)) / int64(interval)
-			torrents[k].LastDlBytes = dlcurprog
-			torrents[k].DlSpeedReadable = humanize.Bytes(uint64(torrents[k].DlSpeedBytes)) + "/s"
+			th.DlSpeedBytes = (int64(interval) * (dlcurprog - th.LastDlBytes)) / int64(interval)
+			th.LastDlBytes = dlcurprog
+			th.DlSpeedReadable = humanize.Bytes(uint64(th.DlSpeedBytes)) + "/s"
 
 			/* Upload speed */
 			ulcurprog := curstats.BytesWritten.Int64()
-			torrents[k].UlSpeedBytes = (int64(interval) * (ulcurprog - torrents[k].LastUlBytes)) / int64(interval)
-			torrents[k].LastUlBytes = ulcurprog
-			torrents[k].UlSpeedReadable = humanize.Bytes(uint64(torrents[k].UlSpeedBytes)) + "/s"
+			th.UlSpeedBytes = (int64(interval) * (ulcurprog - th.LastUlBytes)) / int64(interval)
+			th.LastUlBytes = ulcurprog
+			th.UlSpeedReadable = humanize.Bytes(uint64(th.UlSpeedBytes)) + "/s"
+		}
+		updatePeerSpeeds(interval)
+		if time.Since(lastSave) >= uploadSaveInterval {
+			Engine.saveUploads()
+			lastSave = time.Now()
 		}
 		time.Sleep(interval)
 	}
 }
+
+// Returns the uploaded payload bytes of all the sessions
+func (th *torrentHandle) uploadedBytes() int64 {
+	return th.PrevUlDataBytes + th.LastUlDataBytes
+}
+
+// Persists the uploaded bytes of the torrents changed since the last save
+func (Engine *btEng) saveUploads() {
+	for _, th := range Engine.torrentHandles() {
+		ih := th.Torrent.InfoHash().String()
+		uploaded := th.uploadedBytes()
+		if uploaded == th.SavedUlDataBytes {
+			continue
+		}
+		uperr := updateSpec(ih, func(s *persistentSpec) {
+			s.UploadedBytes = uploaded
+		})
+		if uperr != nil {
+			Warn.Printf("Cannot update spec \"%s\": %s\n", ih, uperr)
+			continue
+		}
+		th.SavedUlDataBytes = uploaded
+	}
+}
+
+// Records and persists the time the data of the torrent gets completed
+func (th *torrentHandle) trackCompletion() {
+	if th.Torrent.Info() == nil {
+		return
+	}
+	completed := th.Torrent.BytesCompleted() == th.Torrent.Length()
+	if completed == !th.CompletedAt.IsZero() {
+		return
+	}
+
+	/* Data can become incomplete again if it is removed or fails rechecking */
+	th.CompletedAt = time.Time{}
+	if completed {
+		th.CompletedAt = time.Now()
+	}
+	ih := th.Torrent.InfoHash().String()
+	uperr := updateSpec(ih, func(s *persistentSpec) {
+		s.CompletedAt = th.CompletedAt
+	})
+	if uperr != nil {
+		Warn.Printf("Cannot update spec \"%s\": %s\n", ih, uperr)
+	}
+}
@@ -10,22 +10,57 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/metainfo"
 	"github.com/dustin/go-humanize"
+	"github.com/gorilla/mux"
 )
 
 // Function for sending error message as JSON response
 func errorRes(w http.ResponseWriter, error string, code int) {
+	errorResDetails(w, error, "", code)
+}
+
+// Sends error message with details such as the underlying error as JSON response
+func errorResDetails(w http.ResponseWriter, message string, details string, code int) {
+	/* API v2 sends the status code with the structured error body */
+	if isAPIv2(w) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(&jsonErrorResV2{
+			Error: apiErrorDetail{
+				Code:    strings.ReplaceAll(strings.ToLower(http.StatusText(code)), " ", "_"),
+				Status:  code,
+				Message: message,
+				Details: details,
+			},
+		})
+		return
+	}
+
+	/* API v1 keeps the error as a single string */
+	if details != "" {
+		message = message + ": " + details
+	}
 	err := encodeRes(w, &jsonErrorRes{
-		Error: error,
+		Error: message,
 	})
 	if err != nil {
 		w.WriteHeader(code)
-		w.Write([]byte(error))
+		w.Write([]byte(message))
+	}
+}
+
+// Sends the error of getTorrHandle with its matching status code
+func torrHandleErrorRes(w http.ResponseWriter, err error) {
+	code := http.StatusNotFound
+	if errors.Is(err, errInvalidInfoHash) {
+		code = http.StatusBadRequest
 	}
+	errorRes(w, err.Error(), code)
 }
 
 // Compiles infohash, display name, and trackers to *torrent.TorrentSpec
@@ -43,7 +78,7 @@ func makeTorrentSpec(infohash string, displayname string, trackers []string) *to
 func decodeBody(w http.ResponseWriter, body io.Reader, v any) error {
 	err := json.NewDecoder(body).Decode(v)
 	if err != nil {
-		errorRes(w, "JSON Decoder error", http.StatusInternalServerError)
+		errorRes(w, "JSON Decoder error", http.StatusBadRequest)
 	}
 	return err
 }
@@ -75,13 +110,17 @@ func persistSpecToTorrentSpec(spec persistentSpec) *torrent.TorrentSpec {
 }
 
 // Creates a URL for the stream and download of file
-func createFileLink(infohash string, filename string, isFile bool) string {
+func createFileLink(infohash string, index int, isFile bool) string {
 	verb := "stream"
 	if isFile {
 		verb = "file"
 	}
+	return createAPILink(verb, infohash, index)
+}
 
-	link := "/api/" + verb + "/" + infohash + "/" + url.QueryEscape(filename)
+// Creates a URL for an endpoint serving a file of a torrent by its index, which needs no escaping unlike the name
+func createAPILink(verb string, infohash string, index int) string {
+	link := "/api/" + verb + "/index/" + infohash + "/" + strconv.Itoa(index)
 
 	if authEnabled {
 		link = link + "?key=" + url.QueryEscape(apiKey)
@@ -100,8 +139,107 @@ func getTorrentFile(t *torrent.Torrent, displaypath string) (*torrent.File, erro
 	return nil, errors.New("file not found")
 }
 
+// Sets the priority of the pieces covering a byte range of the file
+func setFileRangePriority(f *torrent.File, off int64, length int64, prio torrent.PiecePriority) {
+	t := f.Torrent()
+	pieceLen := t.Info().PieceLength
+	off = max(off, 0)
+	end := min(off+length, f.Length())
+	if pieceLen <= 0 || end <= off {
+		return
+	}
+
+	/* Piece indexes are relative to the torrent */
+	first := int((f.Offset() + off) / pieceLen)
+	last := int((f.Offset() + end - 1) / pieceLen)
+	for i := first; i <= last && i < t.NumPieces(); i++ {
+		t.Piece(i).SetPriority(prio)
+	}
+}
+
+// Get the file handle inside the torrent from its index
+func getTorrentFileByIndex(t *torrent.Torrent, index int) (*torrent.File, error) {
+	files := t.Files()
+	if index < 0 || index >= len(files) {
+		return nil, errors.New("file not found")
+	}
+	return files[index], nil
+}
+
+// Returns the index of the file in its torrent
+func torrentFileIndex(f *torrent.File) int {
+	for i, tf := range f.Torrent().Files() {
+		if tf == f {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the indexes of the files selected by DisplayPath and by index, skipping unknown ones
+func selectedFileIndexes(t *torrent.Torrent, names []string, indexes []int) []int {
+	files := t.Files()
+	selected := []int{}
+	for _, name := range names {
+		for i, f := range files {
+			if f.DisplayPath() == name {
+				selected = append(selected, i)
+				break
+			}
+		}
+	}
+	for _, i := range indexes {
+		if i >= 0 && i < len(files) {
+			selected = append(selected, i)
+		}
+	}
+	return selected
+}
+
+// Gets the torrent and its file from the infohash and the file index or escaped filename of the request
+func requestTorrentFile(w http.ResponseWriter, r *http.Request) (*torrent.Torrent, *torrent.File, bool) {
+	vars := mux.Vars(r)
+
+	/* Get torrent handle from infohash */
+	t, err := btEngine.getTorrHandle(vars["infohash"])
+	if err != nil {
+		torrHandleErrorRes(w, err)
+		return nil, nil, false
+	}
+
+	/* Get torrent file handle from file index */
+	if index, ok := vars["index"]; ok {
+		i, ierr := strconv.Atoi(index)
+		if ierr != nil {
+			errorRes(w, "Invalid file index", http.StatusBadRequest)
+			return nil, nil, false
+		}
+		f, ferr := getTorrentFileByIndex(t, i)
+		if ferr != nil {
+			errorRes(w, ferr.Error(), http.StatusNotFound)
+			return nil, nil, false
+		}
+		return t, f, true
+	}
+
+	/* Unescape given filename */
+	fn, fnerr := url.QueryUnescape(vars["file"])
+	if fnerr != nil {
+		errorResDetails(w, "Filename unescaping error", fnerr.Error(), http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	/* Get torrent file handle from filename */
+	f, ferr := getTorrentFile(t, fn)
+	if ferr != nil {
+		errorRes(w, ferr.Error(), http.StatusNotFound)
+		return nil, nil, false
+	}
+	return t, f, true
+}
+
 // Create config for BitTorrent client with confs from args
-func newBtCliConfs(dir string, noup bool) *torrent.ClientConfig {
+func newBtCliConfs(dir string, noup bool, seed bool) *torrent.ClientConfig {
 	opts := torrent.NewDefaultClientConfig()
 
 	/* Disables upload if ENV variable is set to true */
@@ -112,6 +250,9 @@ func newBtCliConfs(dir string, noup bool) *torrent.ClientConfig {
 	/* Sets the variables */
 	opts.DataDir = filepath.Clean(dir)
 	opts.NoUpload = noup
+	opts.Seed = seed && !noup
+	opts.IPBlocklist = peerFilter
+	trackPeers(opts)
 	return opts
 }
 
@@ -123,16 +264,17 @@ func safenDisplayPath(displaypath string) string {
 // Create response for apiAddTorrent and apiAddTorrentFile
 func createAddTorrentRes(t *torrent.Torrent) apiAddTorrentRes {
 	res := apiAddTorrentRes{
-		Name:          t.Name(),
+		Name:          torrentName(t),
 		InfoHash:      t.InfoHash().String(),
 		TotalPeers:    t.Stats().TotalPeers,
 		ActivePeers:   t.Stats().ActivePeers,
 		PendingPeers:  t.Stats().PendingPeers,
 		HalfOpenPeers: t.Stats().HalfOpenPeers,
 	}
-	for _, f := range t.Files() {
+	for i, f := range t.Files() {
 		tfsz := f.Length()
 		res.Files = append(res.Files, apiTorrentFiles{
+			Index:            i,
 			FileName:         f.DisplayPath(),
 			FileSizeBytes:    int(tfsz),
 			FileSizeReadable: humanize.Bytes(uint64(tfsz)),
@@ -147,6 +289,76 @@ func calcTorrentProgress(t *torrent.Torrent) string {
 	return humanize.Bytes(uint64(torrcompleted)) + "/" + humanize.Bytes(uint64(torrlen))
 }
 
+// Returns the completed fraction of the bytes, 1 for empty files
+func progressFraction(completed int64, length int64) float64 {
+	if length <= 0 {
+		return 1
+	}
+	return float64(completed) / float64(length)
+}
+
+// Returns the seconds left to download the selected files at the current speed, -1 if unknown
+func calcTorrentETA(th *torrentHandle) int64 {
+	if th.Torrent.Info() == nil {
+		return -1
+	}
+	missing := wantedBytesMissing(th.Torrent)
+	if missing == 0 {
+		return 0
+	}
+	if th.DlSpeedBytes <= 0 {
+		return -1
+	}
+	return (missing + th.DlSpeedBytes - 1) / th.DlSpeedBytes
+}
+
+// Returns the bytes left of the files not set to priority none
+func wantedBytesMissing(t *torrent.Torrent) int64 {
+	var missing int64
+	for _, f := range t.Files() {
+		if f.Priority() > torrent.PiecePriorityNone {
+			missing += f.Length() - f.BytesCompleted()
+		}
+	}
+	return missing
+}
+
+// Returns the run-length piece map of the torrent as space separated runs of
+// count, state (C complete, P partial, K checking, M missing) and priority
+// (- none, . normal, H high, R readahead, N next, ! now)
+func torrentPieceMap(t *torrent.Torrent) string {
+	if t.Info() == nil {
+		return ""
+	}
+	runs := []string{}
+	for _, run := range t.PieceStateRuns() {
+		state := "M"
+		switch {
+		case run.Checking || run.Hashing || run.QueuedForHash:
+			state = "K"
+		case run.Complete:
+			state = "C"
+		case run.Partial:
+			state = "P"
+		}
+		prio := "-"
+		switch run.Priority {
+		case torrent.PiecePriorityNormal:
+			prio = "."
+		case torrent.PiecePriorityHigh:
+			prio = "H"
+		case torrent.PiecePriorityReadahead:
+			prio = "R"
+		case torrent.PiecePriorityNext:
+			prio = "N"
+		case torrent.PiecePriorityNow:
+			prio = "!"
+		}
+		runs = append(runs, strconv.Itoa(run.Length)+state+prio)
+	}
+	return strings.Join(runs, " ")
+}
+
 func torrentPriorityToString(p torrent.PiecePriority) string {
 	var returnString string
 	switch p {
@@ -162,8 +374,14 @@ func torrentPriorityToString(p torrent.PiecePriority) string {
 	return returnString
 }
 
-func appendFilePlaylist(scheme string, host string, infohash string, name string) string {
-	playList := "#EXTINF:-1," + safenDisplayPath(name) + "\n"
-	playList += scheme + "://" + host + createFileLink(infohash, name, false) + "\n"
-	return playList
+// Returns the scheme and host the client used to reach the server
+func requestBaseURL(r *http.Request) string {
+	httpScheme := "http"
+	if r.TLS != nil {
+		httpScheme = "https"
+	}
+	if r.Header.Get("X-Forwarded-Proto") != "" {
+		httpScheme = r.Header.Get("X-Forwarded-Proto")
+	}
+	return httpScheme + "://" + r.Host
 }
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Files set to priority none are not waited for
+func TestWantedBytesMissing(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{
+		"done.txt":    strings.Repeat("d", 16<<10),
+		"skipped.txt": strings.Repeat("s", 16<<10),
+	}, "skipped.txt")
+
+	for _, f := range tt.Files() {
+		f.SetPriority(torrent.PiecePriorityNormal)
+	}
+	if missing := wantedBytesMissing(tt); missing != 16<<10 {
+		t.Errorf("Missing %d bytes with all files wanted", missing)
+	}
+
+	f, _ := getTorrentFile(tt, "skipped.txt")
+	f.SetPriority(torrent.PiecePriorityNone)
+	if missing := wantedBytesMissing(tt); missing != 0 {
+		t.Errorf("Missing %d bytes with the incomplete file skipped", missing)
+	}
+}
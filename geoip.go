@@ -0,0 +1,115 @@
+/* Contains the country lookup of peer addresses from a local GeoIP database */
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Range of addresses located in a country
+type geoIPRange struct {
+	Start   netip.Addr
+	End     netip.Addr
+	Country string
+}
+
+var (
+	/* Address ranges sorted by their start, empty if GeoIP is disabled */
+	geoIPRanges []geoIPRange
+)
+
+// Loads the GeoIP database, a CSV file of start address, end address and country code rows
+func initGeoIP(path string) {
+	if path == "" {
+		return
+	}
+
+	ranges, err := loadGeoIP(path)
+	if err != nil {
+		Warn.Printf("GeoIP lookup is disabled as its database cannot be loaded: %s\n", err)
+		return
+	}
+	geoIPRanges = ranges
+	Info.Printf("GeoIP lookup is enabled with %d ranges\n", len(ranges))
+}
+
+// Reads the ranges of a GeoIP CSV database, addresses being IPs or IPv4 integers
+func loadGeoIP(path string) ([]geoIPRange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	ranges := []geoIPRange{}
+	for {
+		rec, rerr := r.Read()
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return nil, rerr
+		}
+		if len(rec) < 3 {
+			continue
+		}
+
+		/* Rows that cannot be parsed such as headers are skipped */
+		start, serr := parseGeoIPAddr(rec[0])
+		end, eerr := parseGeoIPAddr(rec[1])
+		country := strings.ToUpper(strings.TrimSpace(rec[2]))
+		if serr != nil || eerr != nil || country == "" || country == "-" || start.Is4() != end.Is4() {
+			continue
+		}
+		ranges = append(ranges, geoIPRange{Start: start, End: end, Country: country})
+	}
+	if len(ranges) == 0 {
+		return nil, errors.New("no address range found")
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start.Less(ranges[j].Start)
+	})
+	return ranges, nil
+}
+
+// Parses an address given as an IP or an IPv4 integer
+func parseGeoIPAddr(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		return netip.AddrFrom4(b), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	return addr.Unmap(), err
+}
+
+// Returns the country code of the address, empty if unknown
+func geoIPCountry(host string) string {
+	addr, err := netip.ParseAddr(host)
+	if err != nil || len(geoIPRanges) == 0 {
+		return ""
+	}
+	addr = addr.Unmap()
+
+	/* Last range starting at or before the address */
+	i := sort.Search(len(geoIPRanges), func(i int) bool {
+		return addr.Less(geoIPRanges[i].Start)
+	}) - 1
+	if i < 0 || geoIPRanges[i].End.Less(addr) || geoIPRanges[i].Start.Is4() != addr.Is4() {
+		return ""
+	}
+	return geoIPRanges[i].Country
+}
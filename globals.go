@@ -3,8 +3,10 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/anacrolix/torrent"
@@ -26,6 +28,12 @@ var (
 	Warn = log.New(os.Stderr, "["+time.Now().Format("2006/01/02 15:04:05")+"] [WARN] ", log.Lmsgprefix)
 	// For critical errors
 	Error = log.New(os.Stderr, "["+time.Now().Format("2006/01/02 15:04:05")+"] [ERROR] ", log.Lmsgprefix)
+
+	/* Errors */
+	errInvalidInfoHash    = errors.New("invalid infohash")
+	errTorrentNotFound    = errors.New("torrent not found")
+	errSpecNotFound       = errors.New("torrent spec not found")
+	errTorrentInfoTimeout = errors.New("timed out waiting for torrent info")
 )
 
 /* Structs for non-HTTP handlers */
@@ -35,6 +43,9 @@ type (
 		Client       *torrent.Client
 		ClientConfig *torrent.ClientConfig
 		Torrents     map[string]*torrentHandle
+
+		// Guards the adding and removal of torrent handles
+		TorrentsMu sync.RWMutex
 	}
 
 	// Struct for persistent spec
@@ -49,7 +60,13 @@ type (
 		DisableInitialPieceCheck bool
 		DisallowDataUpload       bool
 		DisallowDataDownload     bool
+		NoDefaultTrackers        bool
+		DisplayNameEdited        bool
+		Labels                   []string
 		Files                    []persistentSpecFiles
+		AddedAt                  time.Time
+		CompletedAt              time.Time
+		UploadedBytes            int64
 	}
 
 	persistentSpecFiles struct {
@@ -62,6 +79,26 @@ type (
 		/* Main handles */
 		Torrent *torrent.Torrent
 		Spec    *torrent.TorrentSpec
+		AddedAt time.Time
+
+		// Time all the data of the torrent was completed, zero if incomplete
+		CompletedAt time.Time
+
+		// The default trackers are not added to the torrent
+		NoDefaultTrackers bool
+
+		// Trackers added from the default list, left out of the spec
+		DefaultTrackers []string
+		// Guards DefaultTrackers, changed by reloads of the list and by added trackers
+		DefaultTrackersMu sync.Mutex
+
+		/* Properties editable after adding */
+		// Name given by the user, empty to use the torrent name
+		DisplayName          string
+		Webseeds             []string
+		DisallowDataUpload   bool
+		DisallowDataDownload bool
+		Labels               []string
 
 		/* Stats */
 		DlSpeedBytes    int64
@@ -72,6 +109,15 @@ type (
 		/* Temporary */
 		LastDlBytes int64
 		LastUlBytes int64
+
+		// Uploaded payload bytes of the current session
+		LastUlDataBytes int64
+
+		// Uploaded payload bytes of the previous sessions
+		PrevUlDataBytes int64
+
+		// Uploaded payload bytes last persisted
+		SavedUlDataBytes int64
 	}
 )
 
@@ -82,6 +128,19 @@ type (
 		Error string `json:"error"`
 	}
 
+	// Response of JSON error on API v2
+	jsonErrorResV2 struct {
+		Error apiErrorDetail `json:"error"`
+	}
+
+	// Structured error of API v2
+	apiErrorDetail struct {
+		Code    string `json:"code"`
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+		Details string `json:"details,omitempty"`
+	}
+
 	// Expected request body to addTorrent
 	apiAddTorrentBody struct {
 		Magnet string `json:"magnet"`
@@ -93,6 +152,9 @@ type (
 		InfoHash    string   `json:"infohash"`
 		DisplayName string   `json:"displayname"`
 		Trackers    []string `json:"trackers"`
+
+		// Opts out of the default trackers
+		NoDefaultTrackers bool `json:"nodefaulttrackers"`
 	}
 
 	// Expected response from addTorrent
@@ -108,6 +170,7 @@ type (
 
 	// Struct for files in torrent
 	apiTorrentFiles struct {
+		Index            int    `json:"index"`
 		FileName         string `json:"filename"`
 		FileSizeBytes    int    `json:"filesizebytes"`
 		FileSizeReadable string `json:"filesize"`
@@ -118,6 +181,7 @@ type (
 		InfoHash string   `json:"infohash"`
 		AllFiles bool     `json:"allfiles"`
 		Files    []string `json:"files"`
+		Indexes  []int    `json:"indexes"`
 	}
 
 	// Expected response body from selectFile
@@ -129,6 +193,7 @@ type (
 
 	// Struct for selectFile Files
 	apiTorrentSelectFileResFiles struct {
+		Index    int    `json:"index"`
 		FileName string `json:"filename"`
 		Stream   string `json:"stream"`
 		Download string `json:"download"`
@@ -140,6 +205,7 @@ type (
 		Priority string   `json:"priority"`
 		AllFiles bool     `json:"allfiles"`
 		Files    []string `json:"files"`
+		Indexes  []int    `json:"indexes"`
 	}
 
 	// Expected response body from selectFile
@@ -152,6 +218,7 @@ type (
 
 	// Struct for selectFile Files
 	apiTorrentPriorityFileResFiles struct {
+		Index    int    `json:"index"`
 		FileName string `json:"filename"`
 	}
 
@@ -169,36 +236,192 @@ type (
 
 	// Expected response body from torrentStats
 	apiTorrentStasRes struct {
-		Torrents []apiTorrentStasResTorrents `json:"torrents"`
+		Torrents           []apiTorrentStasResTorrents `json:"torrents"`
+		Total              int                         `json:"total"`
+		BlockedConnections int64                       `json:"blockedconnections"`
+	}
+
+	// Response body of torrent stats with selected fields only
+	apiTorrentStatsFieldsRes struct {
+		Torrents           []map[string]any `json:"torrents"`
+		Total              int              `json:"total"`
+		BlockedConnections int64            `json:"blockedconnections"`
 	}
 
 	apiTorrentStasResTorrents struct {
-		Name          string                         `json:"name"`
-		InfoHash      string                         `json:"infohash"`
-		TotalPeers    int                            `json:"totalpeers"`
-		ActivePeers   int                            `json:"activepeers"`
-		PendingPeers  int                            `json:"pendingpeers"`
-		HalfOpenPeers int                            `json:"halfopenpeers"`
-		Peers         []apiTorrentStatsPeersInfo     `json:"peers"`
-		DownloadSpeed string                         `json:"downloadspeed"`
-		UploadSpeed   string                         `json:"uploadspeed"`
-		Progress      string                         `json:"progress"`
-		Files         []apiTorrentStatsTorrentsFiles `json:"files"`
+		Name               string                         `json:"name"`
+		InfoHash           string                         `json:"infohash"`
+		TotalPeers         int                            `json:"totalpeers"`
+		ActivePeers        int                            `json:"activepeers"`
+		PendingPeers       int                            `json:"pendingpeers"`
+		HalfOpenPeers      int                            `json:"halfopenpeers"`
+		Peers              []apiTorrentStatsPeersInfo     `json:"peers"`
+		DownloadSpeed      string                         `json:"downloadspeed,omitempty"`
+		UploadSpeed        string                         `json:"uploadspeed,omitempty"`
+		Progress           string                         `json:"progress,omitempty"`
+		Labels             []string                       `json:"labels"`
+		SizeBytes          int64                          `json:"sizebytes"`
+		DownloadedBytes    int64                          `json:"downloadedbytes"`
+		UploadedBytes      int64                          `json:"uploadedbytes"`
+		DownloadSpeedBytes int64                          `json:"downloadspeedbytes"`
+		UploadSpeedBytes   int64                          `json:"uploadspeedbytes"`
+		Ratio              float64                        `json:"ratio"`
+		Completed          bool                           `json:"completed"`
+		Seeding            bool                           `json:"seeding"`
+		SeedingTime        int64                          `json:"seedingtime"`
+		AddedAt            time.Time                      `json:"addedat"`
+		CompletedAt        *time.Time                     `json:"completedat,omitempty"`
+		ProgressFraction   float64                        `json:"progressfraction"`
+		PieceMap           string                         `json:"piecemap"`
+		ETA                int64                          `json:"eta"`
+		Files              []apiTorrentStatsTorrentsFiles `json:"files"`
 	}
 
 	apiTorrentStatsTorrentsFiles struct {
-		FileName           string `json:"filename"`
-		FileSizeBytes      int    `json:"filesizebytes"`
-		FileSizeReadable   string `json:"filesize"`
-		DownloadedBytes    int    `json:"downloadedbytes"`
-		DownloadedReadable string `json:"downloaded"`
-		Priority           string `json:"priority"`
-		Stream             string `json:"stream,omitempty"`
-		Download           string `json:"download,omitempty"`
+		Index              int            `json:"index"`
+		FileName           string         `json:"filename"`
+		FileSizeBytes      int            `json:"filesizebytes"`
+		FileSizeReadable   string         `json:"filesize,omitempty"`
+		DownloadedBytes    int            `json:"downloadedbytes"`
+		DownloadedReadable string         `json:"downloaded,omitempty"`
+		Completed          bool           `json:"completed"`
+		ProgressFraction   float64        `json:"progressfraction"`
+		Priority           string         `json:"priority"`
+		Stream             string         `json:"stream,omitempty"`
+		Download           string         `json:"download,omitempty"`
+		Subtitles          []apiSubtitle  `json:"subtitles,omitempty"`
+		CompletedRanges    []apiByteRange `json:"completedranges"`
+	}
+
+	// Struct for an inclusive byte range of a file
+	apiByteRange struct {
+		Start int64 `json:"start"`
+		End   int64 `json:"end"`
+	}
+
+	// Struct for subtitles of a video file
+	apiSubtitle struct {
+		FileName string `json:"filename"`
+		Language string `json:"language"`
+		Link     string `json:"link"`
 	}
 
 	apiTorrentStatsPeersInfo struct {
-		PeerAddr   string `json:"peeraddr"`
-		PeerClient string `json:"peercli"`
+		PeerAddr           string  `json:"peeraddr"`
+		PeerClient         string  `json:"peercli"`
+		ConnType           string  `json:"conntype"`
+		Encrypted          bool    `json:"encrypted"`
+		Source             string  `json:"source"`
+		PeerChoking        *bool   `json:"peerchoking,omitempty"`
+		PeerInterested     *bool   `json:"peerinterested,omitempty"`
+		Seed               bool    `json:"seed"`
+		ProgressFraction   float64 `json:"progressfraction"`
+		DownloadedBytes    int64   `json:"downloadedbytes"`
+		DownloadSpeedBytes int64   `json:"downloadspeedbytes"`
+		Country            string  `json:"country,omitempty"`
+	}
+
+	// Expected response body from streamSessions
+	apiStreamSessionsRes struct {
+		Sessions []apiStreamSession `json:"sessions"`
+	}
+
+	// Struct for a stream session
+	apiStreamSession struct {
+		ID       string    `json:"id"`
+		ClientIP string    `json:"clientip"`
+		InfoHash string    `json:"infohash"`
+		FileName string    `json:"filename"`
+		Position int64     `json:"position"`
+		Served   int64     `json:"servedbytes"`
+		Started  time.Time `json:"started"`
+	}
+
+	// Expected request body for adding peers
+	apiPeersBody struct {
+		Peers []string `json:"peers"`
+	}
+
+	// Expected response body from adding peers
+	apiPeersRes struct {
+		InfoHash string   `json:"infohash"`
+		Peers    []string `json:"peers"`
+	}
+
+	// Expected response body from disconnecting a peer
+	apiDisconnectPeerRes struct {
+		InfoHash string `json:"infohash"`
+		Peer     string `json:"peer"`
+	}
+
+	// Expected request body for banning or unbanning IPs and ranges
+	apiBansBody struct {
+		IPs []string `json:"ips"`
+	}
+
+	// Expected response body from reloading the blocklist
+	apiBlocklistRes struct {
+		File               string `json:"file"`
+		Ranges             int    `json:"ranges"`
+		BlockedConnections int64  `json:"blockedconnections"`
+	}
+
+	// Expected request body for editing torrent properties, absent ones being unchanged
+	apiTorrentPropertiesBody struct {
+		DisplayName          *string   `json:"displayname"`
+		Webseeds             *[]string `json:"webseeds"`
+		DisallowDataUpload   *bool     `json:"disallowdataupload"`
+		DisallowDataDownload *bool     `json:"disallowdatadownload"`
+		Labels               *[]string `json:"labels"`
+	}
+
+	// Expected response body from editing torrent properties
+	apiTorrentPropertiesRes struct {
+		InfoHash             string   `json:"infohash"`
+		Name                 string   `json:"name"`
+		DisplayName          string   `json:"displayname"`
+		Webseeds             []string `json:"webseeds"`
+		DisallowDataUpload   bool     `json:"disallowdataupload"`
+		DisallowDataDownload bool     `json:"disallowdatadownload"`
+		Labels               []string `json:"labels"`
+	}
+
+	// Expected response body from default trackers
+	apiDefaultTrackersRes struct {
+		File     string   `json:"file"`
+		Trackers []string `json:"trackers"`
+	}
+
+	// Expected response body from bans
+	apiBansRes struct {
+		Bans []string `json:"bans"`
+	}
+
+	// Expected request body for adding or removing trackers
+	apiTrackersBody struct {
+		Trackers []string `json:"trackers"`
+
+		// Tier the trackers are added to, each getting a new tier if absent
+		Tier *int `json:"tier,omitempty"`
+	}
+
+	// Expected response body from trackers
+	apiTrackersRes struct {
+		InfoHash string       `json:"infohash"`
+		Trackers []apiTracker `json:"trackers"`
+	}
+
+	// Struct for a tracker of a torrent with its last scrape
+	apiTracker struct {
+		URL     string `json:"url"`
+		Tier    int    `json:"tier"`
+		Default bool   `json:"default"`
+		// Status of the last scrape, the announces being made apart by the client
+		ScrapeStatus string     `json:"scrapestatus"`
+		ScrapeError  string     `json:"scrapeerror,omitempty"`
+		LastScrape   *time.Time `json:"lastscrape,omitempty"`
+		Seeders      int32      `json:"seeders"`
+		Leechers     int32      `json:"leechers"`
+		Downloaded   int32      `json:"downloaded"`
 	}
 )
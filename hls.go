@@ -0,0 +1,288 @@
+/* Contains the HLS remux streaming of torrent files */
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/gorilla/mux"
+)
+
+// Remuxes media to MPEG-TS segments without transcoding
+type hlsRemuxer interface {
+	// Returns the duration of the media in seconds
+	Probe(ctx context.Context, input string) (float64, error)
+	// Writes the MPEG-TS segment starting at start lasting duration seconds
+	Segment(ctx context.Context, input string, start float64, duration float64, w io.Writer) error
+}
+
+// Remuxer using local ffmpeg and ffprobe binaries
+type ffmpegRemuxer struct {
+	FFmpeg  string
+	FFprobe string
+}
+
+// Local HTTP server feeding torrent files to the remuxer
+type hlsSource struct {
+	Addr   string
+	Secret string
+}
+
+var (
+	/* Remuxer of HLS segments, nil if HLS is disabled */
+	hlsBackend hlsRemuxer
+
+	/* Local source of the remuxer input */
+	hlsInput hlsSource
+
+	/* Length of each HLS segment in seconds */
+	hlsSegmentSecs = 6.0
+
+	/* Cache of probed media durations keyed by infohash and file */
+	hlsDurations   = map[string]float64{}
+	hlsDurationsMu sync.Mutex
+)
+
+// Enables HLS streaming if ffmpeg and ffprobe are available
+func initHLS(ffmpeg string, ffprobe string, segmentSecs float64) {
+	ffmpegPath, fferr := exec.LookPath(ffmpeg)
+	ffprobePath, fperr := exec.LookPath(ffprobe)
+	if fferr != nil || fperr != nil {
+		Warn.Println("HLS streaming is disabled as ffmpeg or ffprobe is not found")
+		return
+	}
+
+	src, err := startHLSSource()
+	if err != nil {
+		Warn.Printf("HLS streaming is disabled as its source cannot start: %s\n", err)
+		return
+	}
+
+	if segmentSecs > 0 {
+		hlsSegmentSecs = segmentSecs
+	}
+	hlsInput = src
+	hlsBackend = &ffmpegRemuxer{
+		FFmpeg:  ffmpegPath,
+		FFprobe: ffprobePath,
+	}
+	Info.Printf("HLS streaming is enabled using: %s\n", ffmpegPath)
+}
+
+// Starts the loopback HTTP server serving torrent files to the remuxer
+func startHLSSource() (hlsSource, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return hlsSource{}, err
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return hlsSource{}, err
+	}
+
+	src := hlsSource{
+		Addr:   l.Addr().String(),
+		Secret: hex.EncodeToString(secret),
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/"+src.Secret+"/{infohash}/{index:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		t, terr := btEngine.getTorrHandle(vars["infohash"])
+		if terr != nil {
+			http.Error(w, terr.Error(), http.StatusNotFound)
+			return
+		}
+		index, _ := strconv.Atoi(vars["index"])
+		f, ferr := getTorrentFileByIndex(t, index)
+		if ferr != nil {
+			http.Error(w, ferr.Error(), http.StatusNotFound)
+			return
+		}
+
+		reader := newStreamReader(f)
+		defer reader.Close()
+		serveTorrentFile(w, r, t, f, reader)
+	})
+
+	go func() {
+		Error.Fatalln(http.Serve(l, r))
+	}()
+	return src, nil
+}
+
+// URL of a torrent file on the loopback source
+func (src hlsSource) fileURL(f *torrent.File) string {
+	return "http://" + src.Addr + "/" + src.Secret + "/" + f.Torrent().InfoHash().String() + "/" + strconv.Itoa(torrentFileIndex(f))
+}
+
+func (ff *ffmpegRemuxer) Probe(ctx context.Context, input string) (float64, error) {
+	out, err := exec.CommandContext(ctx, ff.FFprobe,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		input,
+	).Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+}
+
+func (ff *ffmpegRemuxer) Segment(ctx context.Context, input string, start float64, duration float64, w io.Writer) error {
+	cmd := exec.CommandContext(ctx, ff.FFmpeg,
+		"-hide_banner", "-loglevel", "error",
+		"-ss", formatSecs(start),
+		"-i", input,
+		"-t", formatSecs(duration),
+		"-map", "0:v:0?", "-map", "0:a:0?",
+		"-c", "copy",
+		"-muxdelay", "0",
+		"-output_ts_offset", formatSecs(start),
+		"-f", "mpegts",
+		"pipe:1",
+	)
+	cmd.Stdout = w
+	return cmd.Run()
+}
+
+// Formats seconds for ffmpeg arguments
+func formatSecs(secs float64) string {
+	return strconv.FormatFloat(secs, 'f', 3, 64)
+}
+
+// Returns the media duration of a torrent file, probing it once
+func hlsDuration(ctx context.Context, t *torrent.Torrent, f *torrent.File) (float64, error) {
+	key := t.InfoHash().String() + "/" + f.DisplayPath()
+
+	hlsDurationsMu.Lock()
+	duration, ok := hlsDurations[key]
+	hlsDurationsMu.Unlock()
+	if ok {
+		return duration, nil
+	}
+
+	duration, err := hlsBackend.Probe(ctx, hlsInput.fileURL(f))
+	if err != nil {
+		return 0, err
+	}
+	if duration <= 0 {
+		return 0, errors.New("media has no duration")
+	}
+
+	hlsDurationsMu.Lock()
+	hlsDurations[key] = duration
+	hlsDurationsMu.Unlock()
+	return duration, nil
+}
+
+// Gets the torrent and its file from the request variables of HLS endpoints
+func hlsTorrentFile(w http.ResponseWriter, r *http.Request) (*torrent.Torrent, *torrent.File, bool) {
+	if hlsBackend == nil {
+		errorRes(w, "HLS streaming is disabled", http.StatusNotImplemented)
+		return nil, nil, false
+	}
+
+	return requestTorrentFile(w, r)
+}
+
+// Endpoint for the HLS playlist of a file
+func apiHLSPlaylist(w http.ResponseWriter, r *http.Request) {
+	t, f, ok := hlsTorrentFile(w, r)
+	if !ok {
+		return
+	}
+
+	/* Probing reads the container header so the file head is fetched first */
+	duration, err := hlsDuration(r.Context(), t, f)
+	if err != nil {
+		errorResDetails(w, "Media probing error", err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	/* Segment links are relative to the playlist and signed to expire with it, never carrying the API key */
+	exp := r.URL.Query().Get("exp")
+	if exp == "" {
+		exp = strconv.FormatInt(time.Now().Add(signedLinkTTL).Unix(), 10)
+	}
+	segmentQuery := func(seq int) string {
+		if !authEnabled {
+			return ""
+		}
+		segPath := path.Dir(r.URL.Path) + "/" + strconv.Itoa(seq) + ".ts"
+		return "?exp=" + exp + "&sig=" + linkSignature(segPath, exp)
+	}
+
+	/* Create the VOD playlist of fixed length segments */
+	playList := "#EXTM3U\n"
+	playList += "#EXT-X-VERSION:3\n"
+	playList += fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(hlsSegmentSecs+0.999))
+	playList += "#EXT-X-MEDIA-SEQUENCE:0\n"
+	playList += "#EXT-X-PLAYLIST-TYPE:VOD\n"
+	for seq := 0; float64(seq)*hlsSegmentSecs < duration; seq++ {
+		segDuration := min(hlsSegmentSecs, duration-float64(seq)*hlsSegmentSecs)
+		playList += fmt.Sprintf("#EXTINF:%.3f,\n%d.ts%s\n", segDuration, seq, segmentQuery(seq))
+	}
+	playList += "#EXT-X-ENDLIST\n"
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Write([]byte(playList))
+}
+
+// Endpoint for an HLS segment of a file
+func apiHLSSegment(w http.ResponseWriter, r *http.Request) {
+	t, f, ok := hlsTorrentFile(w, r)
+	if !ok {
+		return
+	}
+
+	seq, serr := strconv.Atoi(mux.Vars(r)["segment"])
+	if serr != nil {
+		errorRes(w, "Invalid segment", http.StatusBadRequest)
+		return
+	}
+
+	duration, err := hlsDuration(r.Context(), t, f)
+	if err != nil {
+		errorResDetails(w, "Media probing error", err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	start := float64(seq) * hlsSegmentSecs
+	if start >= duration {
+		errorRes(w, "Segment not found", http.StatusNotFound)
+		return
+	}
+
+	/*
+		Prioritize the pieces of the segment and the next one, estimated from the average bitrate
+		The priority is held by a reader so it is dropped once the segment is served
+	*/
+	begin := int64(float64(f.Length()) * start / duration)
+	end := int64(float64(f.Length()) * (start + 2*hlsSegmentSecs) / duration)
+	prio := f.NewReader()
+	defer prio.Close()
+	prio.SetReadahead(end - begin)
+	prio.Seek(begin, io.SeekStart)
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	segerr := hlsBackend.Segment(r.Context(), hlsInput.fileURL(f), start, hlsSegmentSecs, w)
+	if segerr != nil && r.Context().Err() == nil {
+		Warn.Printf("Cannot remux segment %d of \"%s\": %s\n", seq, f.DisplayPath(), segerr)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/gorilla/mux"
+)
+
+// Remuxer writing the segment boundaries instead of running ffmpeg
+type fakeRemuxer struct {
+	Duration float64
+}
+
+func (fr *fakeRemuxer) Probe(ctx context.Context, input string) (float64, error) {
+	return fr.Duration, nil
+}
+
+func (fr *fakeRemuxer) Segment(ctx context.Context, input string, start float64, duration float64, w io.Writer) error {
+	_, err := fmt.Fprintf(w, "segment %s+%s", formatSecs(start), formatSecs(duration))
+	return err
+}
+
+// Creates a client seeding a torrent of the given files, set as the BitTorrent engine
+// The data of the damaged files is changed after hashing so they are incomplete
+func newTestTorrent(t *testing.T, files map[string]string, damaged ...string) *torrent.Torrent {
+	t.Helper()
+	dir := t.TempDir()
+	root := filepath.Join(dir, "test")
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info := metainfo.Info{PieceLength: 16 << 10}
+	if err := info.BuildFromFilePath(root); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range damaged {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.WriteFile(path, make([]byte, len(files[name])), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mi := metainfo.MetaInfo{}
+	var err error
+	if mi.InfoBytes, err = bencode.Marshal(info); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.DataDir = dir
+	cfg.ListenPort = 0
+	cfg.NoDHT = true
+	cfg.DisableTrackers = true
+	cfg.NoDefaultPortForwarding = true
+	cl, err := torrent.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cl.Close() })
+
+	tt, err := cl.AddTorrent(&mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-tt.GotInfo()
+	tt.VerifyData()
+
+	btEngine = btEng{
+		Client:       cl,
+		ClientConfig: cfg,
+		Torrents:     map[string]*torrentHandle{tt.InfoHash().String(): {Torrent: tt}},
+	}
+	if err := createSpecBucket(); err != nil {
+		t.Fatal(err)
+	}
+	return tt
+}
+
+// Serves a request on the API routes
+func serveAPI(method string, target string, body io.Reader) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	apiRoutes(r.PathPrefix("/api").Subrouter())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, target, body))
+	return w
+}
+
+func TestHLSWithFakeRemuxer(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{"video.mkv": strings.Repeat("v", 100<<10)})
+	hlsBackend = &fakeRemuxer{Duration: 15}
+	t.Cleanup(func() { hlsBackend = nil })
+	base := "/api/hls/" + tt.InfoHash().String() + "/video.mkv/"
+
+	/* Segments cover the duration, the last one being shorter */
+	w := serveAPI(http.MethodGet, base+"index.m3u8", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Playlist status %d: %s", w.Code, w.Body)
+	}
+	playList := w.Body.String()
+	for _, line := range []string{"#EXTINF:6.000,\n0.ts", "#EXTINF:6.000,\n1.ts", "#EXTINF:3.000,\n2.ts", "#EXT-X-ENDLIST"} {
+		if !strings.Contains(playList, line) {
+			t.Errorf("Playlist is missing %q:\n%s", line, playList)
+		}
+	}
+	if strings.Contains(playList, "3.ts") {
+		t.Errorf("Playlist has a segment past the duration:\n%s", playList)
+	}
+
+	w = serveAPI(http.MethodGet, base+"1.ts", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "segment 6.000+6.000" {
+		t.Errorf("Segment status %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "video/mp2t" {
+		t.Errorf("Segment content type %q", ct)
+	}
+
+	w = serveAPI(http.MethodGet, base+"3.ts", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Segment not found") {
+		t.Errorf("Segment past the duration status %d: %s", w.Code, w.Body)
+	}
+}
+
+// Segments of a playlist fetched through a signed link are signed instead of carrying the API key
+func TestHLSSignedSegments(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{"video.mkv": strings.Repeat("v", 100<<10)})
+	hlsBackend = &fakeRemuxer{Duration: 15}
+	authEnabled, apiKey = true, "secret"
+	t.Cleanup(func() { hlsBackend, authEnabled, apiKey = nil, false, "" })
+
+	r := mux.NewRouter()
+	api := r.PathPrefix("/api").Subrouter()
+	api.Use(checkAuth)
+	apiRoutes(api)
+	serve := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	base := "/api/hls/" + tt.InfoHash().String() + "/video.mkv/"
+	w := serve(signLink(base+"index.m3u8", time.Hour))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "secret") {
+		t.Fatalf("Playlist status %d:\n%s", w.Code, w.Body)
+	}
+	segment := ""
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, "1.ts?") {
+			segment = line
+		}
+	}
+	if w = serve(base + segment); w.Code != http.StatusOK || w.Body.String() != "segment 6.000+6.000" {
+		t.Errorf("Signed segment %q status %d: %s", segment, w.Code, w.Body)
+	}
+}
@@ -0,0 +1,139 @@
+/* Contains functions for opening the listeners of the HTTP server */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Listener of the HTTP server
+type apiListener struct {
+	net.Listener
+	Address string
+	Unix    bool
+}
+
+// Repeatable -listen flag
+type listenAddrs []string
+
+func (l *listenAddrs) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listenAddrs) Set(addr string) error {
+	*l = append(*l, addr)
+	return nil
+}
+
+// Opens a listener from "tcp:ADDR", "unix:PATH" or a bare TCP address
+func openListener(addr string, perm os.FileMode) (*apiListener, error) {
+	network, path, found := strings.Cut(addr, ":")
+	if !found || (network != "tcp" && network != "unix") {
+		network = "tcp"
+		path = addr
+	}
+
+	if network == "tcp" {
+		l, err := net.Listen("tcp", path)
+		if err != nil {
+			return nil, err
+		}
+		return &apiListener{Listener: l, Address: path}, nil
+	}
+
+	if path == "" {
+		return nil, errors.New("empty unix socket path")
+	}
+
+	/* Remove stale socket left by an unclean exit */
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New("file exists and is not a socket: " + path)
+		}
+		if rmerr := os.Remove(path); rmerr != nil {
+			return nil, rmerr
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Set the socket file permissions */
+	if cherr := os.Chmod(path, perm); cherr != nil {
+		l.Close()
+		return nil, cherr
+	}
+	return &apiListener{Listener: l, Address: path, Unix: true}, nil
+}
+
+// Opens all listeners, closing the already opened ones on error
+func openListeners(addrs []string, perm string) ([]*apiListener, error) {
+	/* Parse the octal socket permissions */
+	mode, perr := strconv.ParseUint(perm, 8, 32)
+	if perr != nil {
+		return nil, errors.New("invalid socket permissions: " + perm)
+	}
+
+	listeners := []*apiListener{}
+	for _, addr := range addrs {
+		l, err := openListener(addr, os.FileMode(mode))
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// Returns the address of the first TCP listener
+func firstTCPListener(listeners []*apiListener) string {
+	for _, l := range listeners {
+		if !l.Unix {
+			return l.Address
+		}
+	}
+	return ""
+}
+
+// Check if a flag was explicitly set on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Serves the HTTP server on all listeners, TLS being used only on TCP listeners
+func serveListeners(srv *http.Server, listeners []*apiListener, certFile string, keyFile string) error {
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l *apiListener) {
+			if certFile != "" && !l.Unix {
+				Info.Printf("Starting HTTPS server on: %s", l.Address)
+				errc <- srv.ServeTLS(l, certFile, keyFile)
+				return
+			}
+			if l.Unix {
+				Info.Printf("Starting HTTP server on unix socket: %s", l.Address)
+			} else {
+				Info.Printf("Starting HTTP server on port: %s", l.Address)
+			}
+			errc <- srv.Serve(l)
+		}(l)
+	}
+	return <-errc
+}
@@ -0,0 +1,116 @@
+/* Contains the content type detection and HTTP caching of torrent files */
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+var (
+	/* Content types of media and subtitle formats often missing from the system table */
+	mediaTypes = map[string]string{
+		/* Video */
+		".3gp":  "video/3gpp",
+		".avi":  "video/x-msvideo",
+		".flv":  "video/x-flv",
+		".m2ts": "video/mp2t",
+		".m4v":  "video/x-m4v",
+		".mkv":  "video/x-matroska",
+		".mov":  "video/quicktime",
+		".mp4":  "video/mp4",
+		".mpeg": "video/mpeg",
+		".mpg":  "video/mpeg",
+		".ogv":  "video/ogg",
+		".ts":   "video/mp2t",
+		".webm": "video/webm",
+		".wmv":  "video/x-ms-wmv",
+
+		/* Audio */
+		".aac":  "audio/aac",
+		".ac3":  "audio/ac3",
+		".flac": "audio/flac",
+		".m4a":  "audio/mp4",
+		".m4b":  "audio/mp4",
+		".mka":  "audio/x-matroska",
+		".mp3":  "audio/mpeg",
+		".oga":  "audio/ogg",
+		".ogg":  "audio/ogg",
+		".opus": "audio/opus",
+		".wav":  "audio/wav",
+		".wma":  "audio/x-ms-wma",
+
+		/* Subtitles */
+		".ass": "text/x-ssa; charset=utf-8",
+		".srt": "application/x-subrip; charset=utf-8",
+		".ssa": "text/x-ssa; charset=utf-8",
+		".sub": "text/plain; charset=utf-8",
+		".vtt": "text/vtt; charset=utf-8",
+
+		/* Text */
+		".nfo": "text/plain; charset=utf-8",
+		".txt": "text/plain; charset=utf-8",
+	}
+)
+
+// Check if the file is a video
+func isVideoFile(name string) bool {
+	return strings.HasPrefix(mediaTypes[strings.ToLower(filepath.Ext(name))], "video/")
+}
+
+// Check if the file is an audio
+func isAudioFile(name string) bool {
+	return strings.HasPrefix(mediaTypes[strings.ToLower(filepath.Ext(name))], "audio/")
+}
+
+// Returns the content type of a torrent file from its extension or its first bytes
+func fileContentType(f *torrent.File) string {
+	ext := strings.ToLower(filepath.Ext(f.DisplayPath()))
+	if ctype, ok := mediaTypes[ext]; ok {
+		return ctype
+	}
+	if ctype := mime.TypeByExtension(ext); ctype != "" {
+		return ctype
+	}
+
+	/* Sniff the content only if the head of the file is available to not block */
+	state := f.State()
+	if len(state) == 0 || !state[0].Complete {
+		return "application/octet-stream"
+	}
+	reader := f.NewReader()
+	defer reader.Close()
+	head := make([]byte, min(512, f.Length()))
+	n, _ := io.ReadFull(reader, head)
+	return http.DetectContentType(head[:n])
+}
+
+// Returns the ETag of a torrent file which never changes as torrent content is immutable
+func fileETag(infohash string, displaypath string) string {
+	sum := sha1.Sum([]byte(infohash + "/" + displaypath))
+	return "\"" + hex.EncodeToString(sum[:]) + "\""
+}
+
+// Returns the stable modification time of a torrent's files
+func torrentModTime(infohash string) time.Time {
+	if th, ok := btEngine.torrentHandle(infohash); ok && !th.AddedAt.IsZero() {
+		return th.AddedAt
+	}
+	return time.Time{}
+}
+
+// Serves a torrent file with its content type, ETag and modification time for conditional requests
+func serveTorrentFile(w http.ResponseWriter, r *http.Request, t *torrent.Torrent, f *torrent.File, content io.ReadSeeker) {
+	ih := t.InfoHash().String()
+	w.Header().Set("Content-Type", fileContentType(f))
+	w.Header().Set("ETag", fileETag(ih, f.DisplayPath()))
+	http.ServeContent(w, r, f.DisplayPath(), torrentModTime(ih), content)
+}
@@ -0,0 +1,426 @@
+/* Contains the OpenAPI specification of the API */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Description of an endpoint for the OpenAPI specification
+type apiDoc struct {
+	Summary string
+	// Struct of the JSON request body
+	Body any
+	// Form fields of a multipart/form-data request body
+	Form []string
+	// Query parameters
+	Query []string
+	// Struct of the JSON response body
+	Res any
+	// Content type of non-JSON responses
+	ResType string
+}
+
+var (
+	/* Endpoints relative to the API version prefix, keyed by "METHOD /path" */
+	apiDocs = map[string]apiDoc{
+		"POST /addtorrent": {
+			Summary: "Add torrent from magnet link or manual infohash, display name and trackers",
+			Body:    apiAddTorrentBody{},
+			Res:     apiAddTorrentRes{},
+		},
+		"POST /selectfile": {
+			Summary: "Select which file/s to download",
+			Body:    apiTorrentSelectFileBody{},
+			Res:     apiTorrentSelectFileRes{},
+		},
+		"POST /setpriority": {
+			Summary: "Set the priority of file/s",
+			Body:    apiTorrentPriorityFileBody{},
+			Res:     apiTorrentPriorityFileRes{},
+		},
+		"POST /addtorrentfile": {
+			Summary: "Add torrent from an uploaded torrent file",
+			Form:    []string{"torrent", "nodefaulttrackers"},
+			Res:     apiAddTorrentRes{},
+		},
+		"DELETE /removetorrent": {
+			Summary: "Remove torrent and optionally its files",
+			Body:    apiRemoveTorrentBody{},
+			Res:     apiRemoveTorrentRes{},
+		},
+		"GET /stream/{infohash}/{file}": {
+			Summary: "Stream a file from torrent",
+			ResType: "application/octet-stream",
+		},
+		"GET /stream/index/{infohash}/{index}": {
+			Summary: "Stream a file from torrent by index",
+			ResType: "application/octet-stream",
+		},
+		"GET /file/{infohash}/{file}": {
+			Summary: "Download a completed file from torrent, or its completed ranges with partial=true",
+			Query:   []string{"partial"},
+			ResType: "application/octet-stream",
+		},
+		"GET /file/index/{infohash}/{index}": {
+			Summary: "Download a completed file from torrent by index, or its completed ranges with partial=true",
+			Query:   []string{"partial"},
+			ResType: "application/octet-stream",
+		},
+		"GET /archive/{infohash}": {
+			Summary: "Zip or tar archive of the completed files of a torrent or folder",
+			Query:   []string{"folder", "format"},
+			ResType: "application/zip",
+		},
+		"GET /subtitle/{infohash}/{file}": {
+			Summary: "Subtitle file, SRT being converted to WebVTT with format=vtt",
+			Query:   []string{"format"},
+			ResType: "text/vtt",
+		},
+		"GET /subtitle/index/{infohash}/{index}": {
+			Summary: "Subtitle file by index, SRT being converted to WebVTT with format=vtt",
+			Query:   []string{"format"},
+			ResType: "text/vtt",
+		},
+		"GET /hls/index/{infohash}/{index}/index.m3u8": {
+			Summary: "HLS playlist of a file by index remuxed without transcoding",
+			ResType: "application/vnd.apple.mpegurl",
+		},
+		"GET /hls/index/{infohash}/{index}/{segment}.ts": {
+			Summary: "HLS segment of a file by index remuxed without transcoding",
+			ResType: "video/mp2t",
+		},
+		"GET /hls/{infohash}/{file}/index.m3u8": {
+			Summary: "HLS playlist of a file remuxed without transcoding",
+			ResType: "application/vnd.apple.mpegurl",
+		},
+		"GET /hls/{infohash}/{file}/{segment}.ts": {
+			Summary: "HLS segment of a file remuxed without transcoding",
+			ResType: "video/mp2t",
+		},
+		"GET /torrents": {
+			Summary: "Stats of all torrents",
+			Query:   []string{"humanize", "state", "label", "search", "sort", "limit", "offset", "fields"},
+			Res:     apiTorrentStasRes{},
+		},
+		"GET /torrents/{infohash}": {
+			Summary: "Stats of a torrent",
+			Query:   []string{"humanize", "state", "label", "search", "sort", "limit", "offset", "fields"},
+			Res:     apiTorrentStasRes{},
+		},
+		"PATCH /torrents/{infohash}": {
+			Summary: "Edit the display name, webseeds, data allowances and labels of a torrent",
+			Body:    apiTorrentPropertiesBody{},
+			Res:     apiTorrentPropertiesRes{},
+		},
+		"GET /play": {
+			Summary: "Playlist for direct streaming of a magnet link, infohash or torrent file URL",
+			Query:   []string{"magnet", "infohash", "torrent", "dn", "tr", "file", "index", "media", "format", "signed"},
+			ResType: "audio/x-mpegurl",
+		},
+		"GET /playfile": {
+			Summary: "Redirect to the stream of a file selected by index, name regexp or the largest video",
+			Query:   []string{"magnet", "infohash", "torrent", "dn", "tr", "index", "match", "select"},
+			ResType: "application/octet-stream",
+		},
+		"POST /play": {
+			Summary: "Playlist for direct streaming of an uploaded torrent file",
+			Form:    []string{"torrent"},
+			Query:   []string{"dn", "tr", "file", "index", "media", "format", "signed"},
+			ResType: "audio/x-mpegurl",
+		},
+		"GET /streams": {
+			Summary: "Active stream sessions",
+			Res:     apiStreamSessionsRes{},
+		},
+		"DELETE /streams/{id}": {
+			Summary: "Kill a stream session",
+			Res:     apiStreamSession{},
+		},
+		"POST /torrents/{infohash}/peers": {
+			Summary: "Add peer addresses to a torrent, kept on restart",
+			Body:    apiPeersBody{},
+			Res:     apiPeersRes{},
+		},
+		"DELETE /torrents/{infohash}/peers/{peer}": {
+			Summary: "Disconnect a peer from a torrent",
+			Res:     apiDisconnectPeerRes{},
+		},
+		"GET /torrents/{infohash}/trackers": {
+			Summary: "Trackers of a torrent by tier with their scrape status",
+			Res:     apiTrackersRes{},
+		},
+		"POST /torrents/{infohash}/trackers": {
+			Summary: "Add trackers to a torrent, kept on restart",
+			Body:    apiTrackersBody{},
+			Res:     apiTrackersRes{},
+		},
+		"DELETE /torrents/{infohash}/trackers": {
+			Summary: "Remove trackers from a torrent",
+			Body:    apiTrackersBody{},
+			Res:     apiTrackersRes{},
+		},
+		"GET /trackers": {
+			Summary: "Default trackers added to every torrent",
+			Res:     apiDefaultTrackersRes{},
+		},
+		"POST /trackers/reload": {
+			Summary: "Read the default tracker list file again",
+			Res:     apiDefaultTrackersRes{},
+		},
+		"GET /bans": {
+			Summary: "Banned IPs and ranges",
+			Res:     apiBansRes{},
+		},
+		"POST /bans": {
+			Summary: "Ban IPs and CIDR ranges, disconnecting their peers",
+			Body:    apiBansBody{},
+			Res:     apiBansRes{},
+		},
+		"DELETE /bans": {
+			Summary: "Lift bans of IPs and CIDR ranges",
+			Body:    apiBansBody{},
+			Res:     apiBansRes{},
+		},
+		"POST /blocklist/reload": {
+			Summary: "Read the IP blocklist file again",
+			Res:     apiBlocklistRes{},
+		},
+		"GET /openapi.json": {
+			Summary: "OpenAPI specification of the API",
+			ResType: "application/json",
+		},
+	}
+
+	/* Marshal'd OpenAPI specification built from the router */
+	openAPISpec []byte
+
+	/* Matches the variables of a route template */
+	routeVarRegexp = regexp.MustCompile(`{([^}:]+)(:[^}]*)?}`)
+)
+
+// Builds the OpenAPI specification from the routes of the router
+func initOpenAPI(r *mux.Router) {
+	spec, missing, err := buildOpenAPI(r)
+	if err != nil {
+		Error.Fatalf("Cannot build OpenAPI specification: %s\n", err)
+	}
+	for _, route := range missing {
+		Warn.Printf("Route has no OpenAPI specification entry: %s\n", route)
+	}
+	openAPISpec = spec
+}
+
+// Returns the marshal'd specification and the routes without an apiDocs entry
+func buildOpenAPI(r *mux.Router) ([]byte, []string, error) {
+	paths := map[string]map[string]any{}
+	schemas := map[string]any{}
+	missing := []string{}
+
+	werr := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, terr := route.GetPathTemplate()
+		if terr != nil {
+			return nil
+		}
+		methods, merr := route.GetMethods()
+		if merr != nil {
+			// Subrouters have no methods
+			return nil
+		}
+
+		/* Strip the regexp of variables and the API version prefix */
+		path := routeVarRegexp.ReplaceAllString(tpl, "{$1}")
+		isV2 := strings.HasPrefix(path, "/api/v2/")
+		rel := strings.TrimPrefix(strings.TrimPrefix(path, "/api/v2"), "/api")
+
+		for _, method := range methods {
+			doc, ok := apiDocs[method+" "+rel]
+			if !ok {
+				missing = append(missing, method+" "+path)
+				continue
+			}
+			if paths[path] == nil {
+				paths[path] = map[string]any{}
+			}
+			paths[path][strings.ToLower(method)] = openAPIOperation(doc, path, isV2, schemas)
+		}
+		return nil
+	})
+	if werr != nil {
+		return nil, nil, werr
+	}
+	sort.Strings(missing)
+
+	spec := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "torrenttp",
+			"description": "Simple REST API controlled BitTorrent client",
+			"version":     "2",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{
+					"type": "apiKey",
+					"in":   "query",
+					"name": "key",
+				},
+			},
+		},
+	}
+	if authEnabled {
+		spec["security"] = []any{map[string]any{"apiKey": []string{}}}
+	}
+
+	json, err := json.Marshal(spec)
+	return json, missing, err
+}
+
+// Creates the OpenAPI operation object of an endpoint
+func openAPIOperation(doc apiDoc, path string, isV2 bool, schemas map[string]any) map[string]any {
+	op := map[string]any{
+		"summary": doc.Summary,
+	}
+
+	/* Path and query parameters */
+	params := []any{}
+	for _, m := range routeVarRegexp.FindAllStringSubmatch(path, -1) {
+		params = append(params, map[string]any{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+	}
+	for _, q := range doc.Query {
+		params = append(params, map[string]any{
+			"name":   q,
+			"in":     "query",
+			"schema": map[string]any{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	/* Request body */
+	if doc.Body != nil {
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{
+					"schema": openAPISchema(reflect.TypeOf(doc.Body), schemas),
+				},
+			},
+		}
+	}
+	if len(doc.Form) > 0 {
+		props := map[string]any{}
+		for _, field := range doc.Form {
+			props[field] = map[string]any{"type": "string", "format": "binary"}
+		}
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"multipart/form-data": map[string]any{
+					"schema": map[string]any{"type": "object", "properties": props},
+				},
+			},
+		}
+	}
+
+	/* Responses */
+	content := map[string]any{}
+	if doc.Res != nil {
+		content["application/json"] = map[string]any{
+			"schema": openAPISchema(reflect.TypeOf(doc.Res), schemas),
+		}
+	} else if doc.ResType != "" {
+		content[doc.ResType] = map[string]any{
+			"schema": map[string]any{"type": "string", "format": "binary"},
+		}
+	}
+	var errorSchema any = jsonErrorRes{}
+	if isV2 {
+		errorSchema = jsonErrorResV2{}
+	}
+	op["responses"] = map[string]any{
+		"200": map[string]any{
+			"description": "Success",
+			"content":     content,
+		},
+		"default": map[string]any{
+			"description": "Error",
+			"content": map[string]any{
+				"application/json": map[string]any{
+					"schema": openAPISchema(reflect.TypeOf(errorSchema), schemas),
+				},
+			},
+		},
+	}
+	return op
+}
+
+// Creates the JSON schema of a type, adding structs to the component schemas
+func openAPISchema(t reflect.Type, schemas map[string]any) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return openAPISchema(t.Elem(), schemas)
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": openAPISchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": openAPISchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		/* time.Time is marshal'd as a string */
+		if t.PkgPath() == "time" && t.Name() == "Time" {
+			return map[string]any{"type": "string", "format": "date-time"}
+		}
+
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := schemas[t.Name()]; ok {
+			return ref
+		}
+		// Reserve the name before recursing for self-referencing structs
+		schemas[t.Name()] = nil
+
+		props := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" || !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			props[name] = openAPISchema(field.Type, schemas)
+		}
+		schemas[t.Name()] = map[string]any{"type": "object", "properties": props}
+		return ref
+	}
+	return map[string]any{}
+}
+
+// Endpoint serving the OpenAPI specification
+func apiOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
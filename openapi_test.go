@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// Every registered route must have an OpenAPI specification entry
+func TestOpenAPIRoutesDocumented(t *testing.T) {
+	r := mux.NewRouter()
+	apiRoutes(r.PathPrefix("/api").Subrouter())
+
+	_, missing, err := buildOpenAPI(r)
+	if err != nil {
+		t.Fatalf("Cannot build OpenAPI specification: %s", err)
+	}
+	for _, route := range missing {
+		t.Errorf("Route has no OpenAPI specification entry: %s", route)
+	}
+}
@@ -0,0 +1,112 @@
+/* Contains the serving of the completed byte ranges of incomplete files */
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Returns the verified byte ranges of a torrent file, merging adjacent pieces
+func fileCompletedRanges(f *torrent.File) []apiByteRange {
+	ranges := []apiByteRange{}
+	var off int64
+	for _, ps := range f.State() {
+		if ps.Complete && ps.Bytes > 0 {
+			if n := len(ranges); n > 0 && ranges[n-1].End == off-1 {
+				ranges[n-1].End = off + ps.Bytes - 1
+			} else {
+				ranges = append(ranges, apiByteRange{Start: off, End: off + ps.Bytes - 1})
+			}
+		}
+		off += ps.Bytes
+	}
+	return ranges
+}
+
+// Formats byte ranges as a comma separated list of HTTP byte ranges
+func formatByteRanges(ranges []apiByteRange) string {
+	s := []string{}
+	for _, br := range ranges {
+		s = append(s, strconv.FormatInt(br.Start, 10)+"-"+strconv.FormatInt(br.End, 10))
+	}
+	return strings.Join(s, ",")
+}
+
+// Parses a single range of a Range header into an inclusive byte range of a file of length size
+func parseSingleRange(header string, size int64) (apiByteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return apiByteRange{}, errors.New("only a single byte range is supported")
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return apiByteRange{}, errors.New("invalid byte range")
+	}
+
+	/* Suffix range of the last bytes of the file */
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return apiByteRange{}, errors.New("invalid byte range")
+		}
+		return apiByteRange{Start: max(size-n, 0), End: size - 1}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return apiByteRange{}, errors.New("invalid byte range")
+	}
+	end := size - 1
+	if last != "" {
+		e, eerr := strconv.ParseInt(last, 10, 64)
+		if eerr != nil || e < start {
+			return apiByteRange{}, errors.New("invalid byte range")
+		}
+		end = min(e, size-1)
+	}
+	return apiByteRange{Start: start, End: end}, nil
+}
+
+// Serves the completed part of the requested range of an incomplete file without blocking on missing pieces
+func servePartialFile(w http.ResponseWriter, r *http.Request, t *torrent.Torrent, f *torrent.File) {
+	completed := fileCompletedRanges(f)
+	w.Header().Set("X-Completed-Ranges", formatByteRanges(completed))
+
+	/* Requests without a range start at the beginning of the file */
+	header := r.Header.Get("Range")
+	if header == "" {
+		header = "bytes=0-"
+	}
+	want, err := parseSingleRange(header, f.Length())
+	if err != nil {
+		w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(f.Length(), 10))
+		errorResDetails(w, "Requested range not satisfiable", err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	/* Trim the range to the completed range containing its start */
+	for _, br := range completed {
+		if want.Start < br.Start || want.Start > br.End {
+			continue
+		}
+		want.End = min(want.End, br.End)
+		r.Header.Set("Range", "bytes="+strconv.FormatInt(want.Start, 10)+"-"+strconv.FormatInt(want.End, 10))
+
+		// A failed If-Range would serve the whole file, blocking on the missing pieces
+		r.Header.Del("If-Range")
+
+		setAttachment(w, f)
+		reader := f.NewReader()
+		defer reader.Close()
+		serveTorrentFile(w, r, t, f, reader)
+		return
+	}
+
+	w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(f.Length(), 10))
+	errorRes(w, "Requested range is not completed", http.StatusRequestedRangeNotSatisfiable)
+}
@@ -0,0 +1,163 @@
+/* Contains the per-peer transfer tracking and statistics */
+
+package main
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// Download counters and remote state of a peer connection observed from its messages
+type peerCounters struct {
+	Downloaded     int64
+	DownloadSpeed  int64
+	PeerChoking    *bool
+	PeerInterested *bool
+
+	/* Temporary */
+	lastDownloaded int64
+}
+
+var (
+	/* Counters of the open peer connections */
+	peerStats   = map[*torrent.PeerConn]*peerCounters{}
+	peerStatsMu sync.Mutex
+
+	/* Names of the peer discovery sources */
+	peerSources = map[torrent.PeerSource]string{
+		torrent.PeerSourceTracker:         "tracker",
+		torrent.PeerSourceIncoming:        "incoming",
+		torrent.PeerSourceDhtGetPeers:     "dht",
+		torrent.PeerSourceDhtAnnouncePeer: "dht",
+		torrent.PeerSourcePex:             "pex",
+		torrent.PeerSourceDirect:          "direct",
+		torrent.PeerSourceUtHolepunch:     "holepunch",
+	}
+)
+
+// Registers the callbacks tracking the peer connections in the client config
+func trackPeers(opts *torrent.ClientConfig) {
+	opts.Callbacks.ReadMessage = trackPeerMessage
+	opts.Callbacks.ReceivedUsefulData = append(opts.Callbacks.ReceivedUsefulData, trackPeerData)
+	opts.Callbacks.PeerConnClosed = func(pc *torrent.PeerConn) {
+		peerStatsMu.Lock()
+		delete(peerStats, pc)
+		peerStatsMu.Unlock()
+	}
+}
+
+// Returns the counters of the peer connection, creating them if missing
+func peerCountersOf(pc *torrent.PeerConn) *peerCounters {
+	pcs, ok := peerStats[pc]
+	if !ok {
+		pcs = &peerCounters{}
+		peerStats[pc] = pcs
+	}
+	return pcs
+}
+
+// Tracks the choking and interest of a peer, unknown until it sends them
+func trackPeerMessage(pc *torrent.PeerConn, msg *pp.Message) {
+	peerStatsMu.Lock()
+	defer peerStatsMu.Unlock()
+
+	pcs := peerCountersOf(pc)
+	switch msg.Type {
+	case pp.Choke, pp.Unchoke:
+		choking := msg.Type == pp.Choke
+		pcs.PeerChoking = &choking
+	case pp.Interested, pp.NotInterested:
+		interested := msg.Type == pp.Interested
+		pcs.PeerInterested = &interested
+	}
+}
+
+// Tracks the useful payload received from a peer
+func trackPeerData(e torrent.ReceivedUsefulDataEvent) {
+	pc, ok := e.Peer.TryAsPeerConn()
+	if !ok {
+		return
+	}
+
+	peerStatsMu.Lock()
+	peerCountersOf(pc).Downloaded += int64(len(e.Message.Piece))
+	peerStatsMu.Unlock()
+}
+
+// Updates the speeds of the peers from the bytes transferred during the interval
+func updatePeerSpeeds(interval time.Duration) {
+	peerStatsMu.Lock()
+	defer peerStatsMu.Unlock()
+
+	for _, pcs := range peerStats {
+		pcs.DownloadSpeed = int64(float64(pcs.Downloaded-pcs.lastDownloaded) / interval.Seconds())
+		pcs.lastDownloaded = pcs.Downloaded
+	}
+}
+
+// Returns the connection flags of a peer, only exposed through its description
+func peerConnFlags(pc *torrent.PeerConn) []string {
+	_, flags, _ := strings.Cut(pc.String(), "flags=")
+	flags, _, _ = strings.Cut(flags, " ")
+	return strings.Split(flags, ",")
+}
+
+// Check if the peer connection is encrypted, fully ("E") or only its header ("e")
+func peerConnEncrypted(pc *torrent.PeerConn) bool {
+	flags := peerConnFlags(pc)
+	return slices.Contains(flags, "E") || slices.Contains(flags, "e")
+}
+
+// Returns the transport of a peer connection
+func peerConnType(pc *torrent.PeerConn) string {
+	switch {
+	case strings.Contains(pc.Network, "webrtc"):
+		return "webrtc"
+	case strings.Contains(pc.Network, "udp"):
+		return "utp"
+	}
+	return "tcp"
+}
+
+// Creates the stats of a peer connection
+func createPeerStatsRes(t *torrent.Torrent, pc *torrent.PeerConn) apiTorrentStatsPeersInfo {
+	pcli, ok := pc.PeerClientName.Load().(string)
+	if !ok {
+		pcli = "NOTPROVIDED"
+	}
+
+	res := apiTorrentStatsPeersInfo{
+		PeerAddr:   pc.RemoteAddr.String(),
+		PeerClient: pcli,
+		ConnType:   peerConnType(pc),
+		Source:     peerSources[pc.Discovery],
+		Encrypted:  peerConnEncrypted(pc),
+	}
+
+	/* Completion of the peer from the pieces it claims */
+	if pieces := t.NumPieces(); t.Info() != nil && pieces > 0 {
+		has := pc.PeerPieces().GetCardinality()
+		res.ProgressFraction = min(float64(has)/float64(pieces), 1)
+		res.Seed = has >= uint64(pieces)
+	}
+
+	peerStatsMu.Lock()
+	if pcs, ok := peerStats[pc]; ok {
+		res.DownloadedBytes = pcs.Downloaded
+		res.DownloadSpeedBytes = pcs.DownloadSpeed
+		res.PeerChoking = pcs.PeerChoking
+		res.PeerInterested = pcs.PeerInterested
+	}
+	peerStatsMu.Unlock()
+
+	if host, _, err := net.SplitHostPort(res.PeerAddr); err == nil {
+		res.Country = geoIPCountry(host)
+	}
+	return res
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// The connection flags are read from the description of the connection
+func TestPeerConnFlags(t *testing.T) {
+	pc := &torrent.PeerConn{}
+	pc.Discovery = torrent.PeerSourcePex
+	if flags := peerConnFlags(pc); len(flags) < 1 || flags[0] != torrent.PeerSourcePex {
+		t.Errorf("Flags %v of %s", flags, pc)
+	}
+	if peerConnEncrypted(pc) {
+		t.Errorf("Unencrypted connection is encrypted")
+	}
+}
+
+// Choking and interest are unknown until the peer sends them
+func TestPeerChokingUnknown(t *testing.T) {
+	pc := &torrent.PeerConn{}
+	t.Cleanup(func() {
+		peerStatsMu.Lock()
+		delete(peerStats, pc)
+		peerStatsMu.Unlock()
+	})
+
+	peerStatsMu.Lock()
+	pcs := peerCountersOf(pc)
+	peerStatsMu.Unlock()
+	if pcs.PeerChoking != nil || pcs.PeerInterested != nil {
+		t.Errorf("Choking and interest are known before any message")
+	}
+
+	trackPeerMessage(pc, &pp.Message{Type: pp.Unchoke})
+	if pcs.PeerChoking == nil || *pcs.PeerChoking {
+		t.Errorf("Unchoked peer is not known as not choking")
+	}
+}
@@ -0,0 +1,192 @@
+/* Contains the playlist formats of direct play */
+
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Validity of signed links embedded in playlists
+const signedLinkTTL = 24 * time.Hour
+
+// Entry of a playlist with absolute links
+type playlistEntry struct {
+	Title     string
+	Link      string
+	Subtitles []string
+}
+
+// XSPF playlist document
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Version string      `xml:"version,attr"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title"`
+}
+
+// Creates the playlist entry of a file, signing its links if requested
+func newPlaylistEntry(baseURL string, subIndex *subtitleIndex, f *torrent.File, signed bool) playlistEntry {
+	t := subIndex.Torrent
+	link := func(link string) string {
+		if signed {
+			link = signLink(link, signedLinkTTL)
+		}
+		return baseURL + link
+	}
+
+	entry := playlistEntry{
+		Title: safenDisplayPath(f.DisplayPath()),
+		Link:  link(createFileLink(t.InfoHash().String(), torrentFileIndex(f), false)),
+	}
+	subs, _ := subIndex.find(f)
+	for _, sub := range subs {
+		entry.Subtitles = append(entry.Subtitles, link(createSubtitleLink(sub)))
+	}
+	return entry
+}
+
+// Check if the file is of one of the media kinds: video, audio, subtitle or all
+func matchMediaKinds(name string, kinds []string) bool {
+	for _, kind := range kinds {
+		switch strings.ToLower(kind) {
+		case "all":
+			return true
+		case "video":
+			if isVideoFile(name) {
+				return true
+			}
+		case "audio":
+			if isAudioFile(name) {
+				return true
+			}
+		case "subtitle":
+			if isSubtitleFile(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Sorts files by display path with numbers compared by value so episodes are in order
+func sortFilesNatural(files []*torrent.File) {
+	sort.SliceStable(files, func(i, j int) bool {
+		return naturalLess(files[i].DisplayPath(), files[j].DisplayPath())
+	})
+}
+
+// Compares strings case-insensitively with digit runs compared as numbers
+func naturalLess(a string, b string) bool {
+	ar, br := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+	i, j := 0, 0
+	for i < len(ar) && j < len(br) {
+		if unicode.IsDigit(ar[i]) && unicode.IsDigit(br[j]) {
+			/* Compare whole numbers */
+			si, sj := i, j
+			for i < len(ar) && unicode.IsDigit(ar[i]) {
+				i++
+			}
+			for j < len(br) && unicode.IsDigit(br[j]) {
+				j++
+			}
+			na := strings.TrimLeft(string(ar[si:i]), "0")
+			nb := strings.TrimLeft(string(br[sj:j]), "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		if ar[i] != br[j] {
+			return ar[i] < br[j]
+		}
+		i++
+		j++
+	}
+	return len(ar)-i < len(br)-j
+}
+
+// Replaces line breaks of torrent names so they cannot add playlist lines
+func playlistLine(s string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(s)
+}
+
+// Formats of the playlists
+var playlistFormats = []string{"", "m3u8", "m3u", "xspf", "pls"}
+
+// Check if the playlist format is known
+func checkPlaylistFormat(format string) error {
+	if !slices.Contains(playlistFormats, strings.ToLower(format)) {
+		return errors.New("unknown playlist format: " + format)
+	}
+	return nil
+}
+
+// Renders the playlist in a format, returning its content type and file extension
+func renderPlaylist(format string, name string, entries []playlistEntry) ([]byte, string, string, error) {
+	switch strings.ToLower(format) {
+	case "", "m3u8", "m3u":
+		playList := "#EXTM3U\n"
+		playList += "#PLAYLIST:" + playlistLine(name) + "\n"
+		for _, entry := range entries {
+			playList += "#EXTINF:-1," + playlistLine(entry.Title) + "\n"
+			/* Subtitles are loaded by VLC as input slaves */
+			for _, sub := range entry.Subtitles {
+				playList += "#EXTVLCOPT:input-slave=" + sub + "\n"
+			}
+			playList += entry.Link + "\n"
+		}
+		if strings.ToLower(format) == "m3u" {
+			return []byte(playList), "audio/x-mpegurl", ".m3u", nil
+		}
+		return []byte(playList), "audio/x-mpegurl; charset=utf-8", ".m3u8", nil
+
+	case "xspf":
+		doc := xspfPlaylist{
+			Version: "1",
+			XMLNS:   "http://xspf.org/ns/0/",
+			Title:   name,
+		}
+		for _, entry := range entries {
+			doc.Tracks = append(doc.Tracks, xspfTrack{
+				Location: entry.Link,
+				Title:    entry.Title,
+			})
+		}
+		out, err := xml.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return nil, "", "", err
+		}
+		return append([]byte(xml.Header), out...), "application/xspf+xml", ".xspf", nil
+
+	case "pls":
+		playList := "[playlist]\n"
+		for i, entry := range entries {
+			n := strconv.Itoa(i + 1)
+			playList += "File" + n + "=" + entry.Link + "\n"
+			playList += "Title" + n + "=" + playlistLine(entry.Title) + "\n"
+			playList += "Length" + n + "=-1\n"
+		}
+		playList += "NumberOfEntries=" + strconv.Itoa(len(entries)) + "\n"
+		playList += "Version=2\n"
+		return []byte(playList), "audio/x-scpls", ".pls", nil
+	}
+	return nil, "", "", errors.New("unknown playlist format: " + format)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// Line breaks of names must not add playlist lines
+func TestRenderPlaylistLineBreaks(t *testing.T) {
+	entries := []playlistEntry{{Title: "a\r\nhttp://evil/\nb", Link: "http://host/file"}}
+	for _, format := range []string{"m3u8", "pls"} {
+		out, _, _, err := renderPlaylist(format, "name\n#EXTINF:-1,x", entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.HasPrefix(line, "http://evil/") || strings.HasPrefix(line, "#EXTINF:-1,x") {
+				t.Errorf("Injected line in %s playlist:\n%s", format, out)
+			}
+		}
+	}
+}
+
+// Unknown formats are refused before the files are selected
+func TestDirectPlayUnknownFormat(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{"video.mkv": "video"})
+	w := serveAPI(http.MethodGet, "/api/play?format=bogus&infohash="+tt.InfoHash().String(), nil)
+	if !strings.Contains(w.Body.String(), "unknown playlist format") {
+		t.Errorf("Response %s", w.Body)
+	}
+	if p := tt.Files()[0].Priority(); p != torrent.PiecePriorityNone {
+		t.Errorf("File priority %v after refusing the format", p)
+	}
+}
+
+// Torrent URLs resolving to local addresses are refused
+func TestFetchTorrentSpecLocalAddress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Local server was reached")
+	}))
+	defer srv.Close()
+	if _, err := fetchTorrentSpec(srv.URL + "/file.torrent"); err == nil || !strings.Contains(err.Error(), "not public") {
+		t.Errorf("Error %v", err)
+	}
+}
+
+// Oversized torrent files are refused
+func TestTorrentSpecFromReaderTooLarge(t *testing.T) {
+	_, err := torrentSpecFromReader(strings.NewReader(strings.Repeat("x", maxTorrentFileSize+1)))
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("Error %v", err)
+	}
+}
+
+// Direct play stops waiting for the info of a torrent without peers
+func TestDirectPlayInfoTimeout(t *testing.T) {
+	newTestTorrent(t, map[string]string{"video.mkv": "video"})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := btEngine.addTorrent(ctx, makeTorrentSpec(strings.Repeat("ab", 20), "", nil), true, true)
+	if !errors.Is(err, errTorrentInfoTimeout) {
+		t.Errorf("Error %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+/* Contains the editing of torrent properties after adding */
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/gorilla/mux"
+)
+
+// Returns the name of a torrent, the display name given by the user first
+func torrentName(t *torrent.Torrent) string {
+	if th, ok := btEngine.torrentHandle(t.InfoHash().String()); ok && th.DisplayName != "" {
+		return th.DisplayName
+	}
+	return t.Name()
+}
+
+// Trims and removes the empty and duplicate labels
+func cleanLabels(labels []string) []string {
+	cleaned := []string{}
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label != "" && !slices.Contains(cleaned, label) {
+			cleaned = append(cleaned, label)
+		}
+	}
+	return cleaned
+}
+
+// Check if the webseeds are HTTP URLs
+func checkWebseeds(webseeds []string) error {
+	for _, ws := range webseeds {
+		u, err := url.Parse(ws)
+		if err != nil {
+			return err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("not an HTTP URL: " + ws)
+		}
+	}
+	return nil
+}
+
+// Creates the response of the properties of a torrent
+func createTorrentPropertiesRes(th *torrentHandle) apiTorrentPropertiesRes {
+	return apiTorrentPropertiesRes{
+		InfoHash:             th.Torrent.InfoHash().String(),
+		Name:                 torrentName(th.Torrent),
+		DisplayName:          th.DisplayName,
+		Webseeds:             append([]string{}, th.Webseeds...),
+		DisallowDataUpload:   th.DisallowDataUpload,
+		DisallowDataDownload: th.DisallowDataDownload,
+		Labels:               append([]string{}, th.Labels...),
+	}
+}
+
+// Endpoint updating the properties of a torrent given in the body
+func apiEditTorrent(w http.ResponseWriter, r *http.Request) {
+	t, err := btEngine.getTorrHandle(mux.Vars(r)["infohash"])
+	if err != nil {
+		torrHandleErrorRes(w, err)
+		return
+	}
+	th, ok := btEngine.torrentHandle(t.InfoHash().String())
+	if !ok {
+		errorRes(w, "Torrent info is not available yet", http.StatusConflict)
+		return
+	}
+
+	body := apiTorrentPropertiesBody{}
+	if decodeBody(w, r.Body, &body) != nil {
+		return
+	}
+	if body.Webseeds != nil {
+		if wserr := checkWebseeds(*body.Webseeds); wserr != nil {
+			errorResDetails(w, "Invalid webseed URL", wserr.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	/* Persist the properties given before changing the live torrent */
+	uperr := updateSpec(t.InfoHash().String(), func(spec *persistentSpec) {
+		// The spec display name is the torrent name until the user edits it
+		if body.DisplayName != nil {
+			spec.DisplayName = strings.TrimSpace(*body.DisplayName)
+			spec.DisplayNameEdited = spec.DisplayName != ""
+		}
+		if body.Webseeds != nil {
+			spec.Webseeds = *body.Webseeds
+		}
+		if body.DisallowDataUpload != nil {
+			spec.DisallowDataUpload = *body.DisallowDataUpload
+		}
+		if body.DisallowDataDownload != nil {
+			spec.DisallowDataDownload = *body.DisallowDataDownload
+		}
+		if body.Labels != nil {
+			spec.Labels = cleanLabels(*body.Labels)
+		}
+	})
+	if errors.Is(uperr, errSpecNotFound) {
+		errorRes(w, "Cannot save properties of a torrent without spec", http.StatusConflict)
+		return
+	}
+	if uperr != nil {
+		errorResDetails(w, "Cannot save torrent properties", uperr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	/* Apply them to the live torrent */
+	if body.DisplayName != nil {
+		th.DisplayName = strings.TrimSpace(*body.DisplayName)
+	}
+	if body.Webseeds != nil {
+		// The client cannot drop webseeds, the removed ones being used until restart
+		th.Webseeds = *body.Webseeds
+		t.AddWebSeeds(th.Webseeds)
+	}
+	if body.DisallowDataUpload != nil {
+		th.DisallowDataUpload = *body.DisallowDataUpload
+		if th.DisallowDataUpload {
+			t.DisallowDataUpload()
+		} else {
+			t.AllowDataUpload()
+		}
+	}
+	if body.DisallowDataDownload != nil {
+		th.DisallowDataDownload = *body.DisallowDataDownload
+		if th.DisallowDataDownload {
+			t.DisallowDataDownload()
+		} else {
+			t.AllowDataDownload()
+		}
+	}
+	if body.Labels != nil {
+		th.Labels = cleanLabels(*body.Labels)
+	}
+
+	res := createTorrentPropertiesRes(th)
+	encodeRes(w, &res)
+}
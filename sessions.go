@@ -0,0 +1,199 @@
+/* Contains the tracking of stream sessions */
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/gorilla/mux"
+)
+
+// Client streaming a torrent file
+type streamSession struct {
+	ID       string
+	ClientIP string
+	InfoHash string
+	File     *torrent.File
+	Started  time.Time
+
+	position atomic.Int64
+	served   atomic.Int64
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+var (
+	/* Active stream sessions keyed by ID */
+	streamSessions   = map[string]*streamSession{}
+	streamSessionsMu sync.Mutex
+
+	/* Reverse proxies whose X-Forwarded-For header is trusted */
+	trustedProxies []netip.Prefix
+)
+
+// Registers a stream session of a request
+func startStreamSession(r *http.Request, t *torrent.Torrent, f *torrent.File) (*streamSession, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	session := &streamSession{
+		ID:       hex.EncodeToString(id),
+		ClientIP: requestClientIP(r),
+		InfoHash: t.InfoHash().String(),
+		File:     f,
+		Started:  time.Now(),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	streamSessionsMu.Lock()
+	streamSessions[session.ID] = session
+	streamSessionsMu.Unlock()
+	return session, nil
+}
+
+// Unregisters a stream session and lowers the file priority if it was the last viewer
+func endStreamSession(session *streamSession) {
+	session.cancel()
+
+	streamSessionsMu.Lock()
+	delete(streamSessions, session.ID)
+	lastViewer := true
+	for _, s := range streamSessions {
+		if s.File == session.File {
+			lastViewer = false
+			break
+		}
+	}
+	streamSessionsMu.Unlock()
+
+	/* Stop the download of the file once no viewer nor other reader is left */
+	if lastViewer && openStreamReaders(session.File) == 0 {
+		f := session.File
+		f.SetPriority(torrent.PiecePriorityNone)
+		if err := saveSpecFile(session.InfoHash, f.DisplayPath(), f.Priority()); err != nil && !errors.Is(err, errSpecNotFound) {
+			Warn.Printf("Cannot update spec \"%s\": %s\n", session.InfoHash, err)
+		}
+	}
+}
+
+// Kills a stream session by ID
+func killStreamSession(id string) (*streamSession, error) {
+	streamSessionsMu.Lock()
+	session, ok := streamSessions[id]
+	streamSessionsMu.Unlock()
+	if !ok {
+		return nil, errors.New("stream session not found")
+	}
+
+	session.cancel()
+	return session, nil
+}
+
+// Sets the reverse proxies trusted to give the client IP from comma separated IPs or CIDR ranges
+func initTrustedProxies(list string) error {
+	for _, proxy := range splitList(list) {
+		p, err := parseBan(proxy)
+		if err != nil {
+			return err
+		}
+		trustedProxies = append(trustedProxies, p)
+	}
+	return nil
+}
+
+// Check if an IP is of a trusted reverse proxy
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the IP address of the client, the one given by X-Forwarded-For only behind a trusted proxy
+func requestClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	/* The nearest address not of a trusted proxy is the client */
+	fwd := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(fwd) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(fwd[i])
+		if ip != "" && !isTrustedProxy(ip) {
+			return ip
+		}
+	}
+	return host
+}
+
+// Creates the response of a stream session
+func createStreamSessionRes(session *streamSession) apiStreamSession {
+	return apiStreamSession{
+		ID:       session.ID,
+		ClientIP: session.ClientIP,
+		InfoHash: session.InfoHash,
+		FileName: session.File.DisplayPath(),
+		Position: session.position.Load(),
+		Served:   session.served.Load(),
+		Started:  session.Started,
+	}
+}
+
+// Endpoint listing the active stream sessions
+func apiStreamSessions(w http.ResponseWriter, r *http.Request) {
+	res := apiStreamSessionsRes{
+		Sessions: []apiStreamSession{},
+	}
+
+	streamSessionsMu.Lock()
+	for _, session := range streamSessions {
+		res.Sessions = append(res.Sessions, createStreamSessionRes(session))
+	}
+	streamSessionsMu.Unlock()
+
+	/* Oldest sessions first */
+	sort.Slice(res.Sessions, func(i, j int) bool {
+		return res.Sessions[i].Started.Before(res.Sessions[j].Started)
+	})
+
+	encodeRes(w, &res)
+}
+
+// Endpoint killing a stream session
+func apiKillStreamSession(w http.ResponseWriter, r *http.Request) {
+	session, err := killStreamSession(mux.Vars(r)["id"])
+	if err != nil {
+		errorRes(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	res := createStreamSessionRes(session)
+	encodeRes(w, &res)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+)
+
+// X-Forwarded-For is only honoured from trusted proxies
+func TestRequestClientIP(t *testing.T) {
+	t.Cleanup(func() { trustedProxies = nil })
+	r := httptest.NewRequest(http.MethodGet, "/api/stream", nil)
+	r.RemoteAddr = "10.0.0.2:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7, 203.0.113.9")
+
+	if ip := requestClientIP(r); ip != "10.0.0.2" {
+		t.Errorf("Client IP %q without trusted proxies", ip)
+	}
+
+	if err := initTrustedProxies("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if ip := requestClientIP(r); ip != "203.0.113.9" {
+		t.Errorf("Client IP %q behind a trusted proxy", ip)
+	}
+}
+
+// The file priority is lowered when the last viewer leaves and no other reader is open
+func TestEndStreamSessionPriority(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{"video.mkv": "video"})
+	f := tt.Files()[0]
+	f.SetPriority(torrent.PiecePriorityNormal)
+	r := httptest.NewRequest(http.MethodGet, "/api/stream", nil)
+
+	other := newStreamReader(f)
+	session, err := startStreamSession(r, tt, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endStreamSession(session)
+	if f.Priority() != torrent.PiecePriorityNormal {
+		t.Errorf("Priority %v lowered while another reader is open", f.Priority())
+	}
+
+	other.Close()
+	session, _ = startStreamSession(r, tt, f)
+	endStreamSession(session)
+	if f.Priority() != torrent.PiecePriorityNone {
+		t.Errorf("Priority %v after the last viewer left", f.Priority())
+	}
+}
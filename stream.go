@@ -0,0 +1,159 @@
+/* Contains the adaptive reader and piece prioritization of streamed files */
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+const (
+	/* Bounds of the stream readahead */
+	streamMinReadahead     = 2 << 20
+	streamInitialReadahead = 32 << 20
+	streamMaxReadahead     = 256 << 20
+
+	/* Seconds of playback kept buffered ahead of the reader */
+	streamBufferSecs = 30
+
+	/* Interval between readahead adjustments */
+	streamAdjustInterval = 2 * time.Second
+
+	/* Bounds of the prioritized head and tail of streamed files */
+	streamMinEdgeBytes = 2 << 20
+	streamMaxEdgeBytes = 16 << 20
+)
+
+var (
+	/* Open stream readers by file */
+	streamReaders   = map[*torrent.File]int{}
+	streamReadersMu sync.Mutex
+)
+
+// Torrent file reader adapting its readahead to the observed bitrate and download speed
+type streamReader struct {
+	torrent.Reader
+	File *torrent.File
+
+	// Session of the reader, nil if untracked
+	Session *streamSession
+
+	mu          sync.Mutex
+	windowStart time.Time
+	windowBytes int64
+	readahead   int64
+}
+
+// Creates a reader for streaming and prioritizes the head and tail of the file
+func newStreamReader(f *torrent.File) *streamReader {
+	sr := &streamReader{
+		Reader:      f.NewReader(),
+		File:        f,
+		windowStart: time.Now(),
+		readahead:   clampInt64(f.Length()/100, streamMinReadahead, streamInitialReadahead),
+	}
+	sr.Reader.SetResponsive()
+	sr.Reader.SetReadahead(sr.readahead)
+
+	/* Container indexes are often at the head or tail of media files */
+	streamReadersMu.Lock()
+	streamReaders[f]++
+	setStreamEdgesPriority(f, torrent.PiecePriorityHigh)
+	streamReadersMu.Unlock()
+
+	return sr
+}
+
+// Closes the reader, lowering the head and tail of the file once no other stream reader needs them
+func (sr *streamReader) Close() error {
+	streamReadersMu.Lock()
+	streamReaders[sr.File]--
+	if streamReaders[sr.File] <= 0 {
+		delete(streamReaders, sr.File)
+		setStreamEdgesPriority(sr.File, torrent.PiecePriorityNone)
+	}
+	streamReadersMu.Unlock()
+
+	return sr.Reader.Close()
+}
+
+// Returns the number of open stream readers of the file
+func openStreamReaders(f *torrent.File) int {
+	streamReadersMu.Lock()
+	defer streamReadersMu.Unlock()
+	return streamReaders[f]
+}
+
+// Sets the priority of the head and tail pieces of a streamed file
+func setStreamEdgesPriority(f *torrent.File, prio torrent.PiecePriority) {
+	edge := clampInt64(f.Length()/200, streamMinEdgeBytes, streamMaxEdgeBytes)
+	setFileRangePriority(f, 0, edge, prio)
+	setFileRangePriority(f, f.Length()-edge, edge, prio)
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	/* Reads of a killed session are aborted */
+	ctx := context.Background()
+	if sr.Session != nil {
+		ctx = sr.Session.ctx
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := sr.Reader.ReadContext(ctx, p)
+	if sr.Session != nil {
+		sr.Session.served.Add(int64(n))
+		sr.Session.position.Add(int64(n))
+	}
+
+	sr.mu.Lock()
+	sr.windowBytes += int64(n)
+	if elapsed := time.Since(sr.windowStart); elapsed >= streamAdjustInterval {
+		sr.adjustReadahead(elapsed)
+	}
+	sr.mu.Unlock()
+
+	return n, err
+}
+
+func (sr *streamReader) Seek(off int64, whence int) (int64, error) {
+	/* Restart the bitrate window as seeking is not playback */
+	sr.mu.Lock()
+	sr.windowStart = time.Now()
+	sr.windowBytes = 0
+	sr.mu.Unlock()
+
+	pos, err := sr.Reader.Seek(off, whence)
+	if err == nil && sr.Session != nil {
+		sr.Session.position.Store(pos)
+	}
+	return pos, err
+}
+
+// Sets the readahead to buffer streamBufferSecs of the observed bitrate
+func (sr *streamReader) adjustReadahead(elapsed time.Duration) {
+	bitrate := int64(float64(sr.windowBytes) / elapsed.Seconds())
+	readahead := bitrate * streamBufferSecs
+
+	/* Buffer more if the download cannot keep up with the playback */
+	if th, ok := btEngine.torrentHandle(sr.File.Torrent().InfoHash().String()); ok && th.DlSpeedBytes < bitrate {
+		readahead *= 2
+	}
+
+	readahead = clampInt64(readahead, streamMinReadahead, streamMaxReadahead)
+	if readahead != sr.readahead {
+		sr.readahead = readahead
+		sr.Reader.SetReadahead(readahead)
+	}
+
+	sr.windowStart = time.Now()
+	sr.windowBytes = 0
+}
+
+// Clamps v between lo and hi
+func clampInt64(v int64, lo int64, hi int64) int64 {
+	return max(lo, min(v, hi))
+}
@@ -0,0 +1,209 @@
+/* Contains the discovery and serving of subtitle files */
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+)
+
+var (
+	/* Extensions of subtitle files */
+	subtitleExts = map[string]bool{
+		".ass": true,
+		".srt": true,
+		".ssa": true,
+		".sub": true,
+		".vtt": true,
+	}
+
+	/* Folders commonly holding subtitles of a torrent */
+	subtitleDirs = map[string]bool{
+		"sub":       true,
+		"subs":      true,
+		"subtitle":  true,
+		"subtitles": true,
+	}
+
+	/* Matches the timing lines of SRT cues */
+	srtTimingRegexp = regexp.MustCompile(`(\d{2}:\d{2}:\d{2}),(\d{3})`)
+)
+
+// Check if the file is a subtitle
+func isSubtitleFile(name string) bool {
+	return subtitleExts[strings.ToLower(path.Ext(name))]
+}
+
+// Returns the file name without its extension
+func trimExt(name string) string {
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// Subtitle files of a torrent, indexed once to be matched with each video
+type subtitleIndex struct {
+	Torrent *torrent.Torrent
+	Videos  int
+
+	// All the subtitles and their position in the torrent
+	All   []*torrent.File
+	Order map[*torrent.File]int
+
+	// Subtitles by folder, by lowercased folder name and in subtitle folders
+	ByDir    map[string][]*torrent.File
+	ByParent map[string][]*torrent.File
+	InSubDir []*torrent.File
+}
+
+// Indexes the subtitle files of a torrent
+func newSubtitleIndex(t *torrent.Torrent) *subtitleIndex {
+	idx := &subtitleIndex{
+		Torrent:  t,
+		Order:    map[*torrent.File]int{},
+		ByDir:    map[string][]*torrent.File{},
+		ByParent: map[string][]*torrent.File{},
+	}
+	for _, f := range t.Files() {
+		if isVideoFile(f.DisplayPath()) {
+			idx.Videos++
+		}
+		if !isSubtitleFile(f.DisplayPath()) {
+			continue
+		}
+
+		subDir, _ := path.Split(f.DisplayPath())
+		parent := strings.ToLower(path.Base(strings.TrimSuffix(subDir, "/")))
+		idx.Order[f] = len(idx.All)
+		idx.All = append(idx.All, f)
+		idx.ByDir[subDir] = append(idx.ByDir[subDir], f)
+		idx.ByParent[parent] = append(idx.ByParent[parent], f)
+		if subtitleDirs[parent] {
+			idx.InSubDir = append(idx.InSubDir, f)
+		}
+	}
+	return idx
+}
+
+// Returns the subtitle files of a video in the torrent with their language
+func (idx *subtitleIndex) find(video *torrent.File) ([]*torrent.File, []string) {
+	subs := []*torrent.File{}
+	langs := []string{}
+	if !isVideoFile(video.DisplayPath()) {
+		return subs, langs
+	}
+
+	videoDir, videoName := path.Split(video.DisplayPath())
+	videoBase := trimExt(videoName)
+
+	/* Subtitles belong to the only video of the torrent regardless of their names */
+	candidates := idx.All
+	if idx.Videos != 1 {
+		candidates = slices.Concat(idx.ByDir[videoDir], idx.InSubDir, idx.ByParent[strings.ToLower(videoBase)])
+		slices.SortFunc(candidates, func(a, b *torrent.File) int {
+			return idx.Order[a] - idx.Order[b]
+		})
+		candidates = slices.Compact(candidates)
+	}
+
+	for _, f := range candidates {
+		subDir, subName := path.Split(f.DisplayPath())
+		subBase := trimExt(subName)
+		parent := strings.ToLower(path.Base(strings.TrimSuffix(subDir, "/")))
+
+		/* The prefix is compared on the original names as lowercasing can change their length */
+		hasVideoPrefix := len(subBase) >= len(videoBase) && strings.EqualFold(subBase[:len(videoBase)], videoBase)
+
+		switch {
+		// "Video.en.srt" or "Video.srt" next to or in a subtitle folder
+		case hasVideoPrefix && (subDir == videoDir || subtitleDirs[parent]):
+			subs = append(subs, f)
+			langs = append(langs, strings.TrimLeft(subBase[len(videoBase):], ".-_ "))
+		// "Subs/Video/English.srt"
+		case parent == strings.ToLower(videoBase):
+			subs = append(subs, f)
+			langs = append(langs, subBase)
+		case idx.Videos == 1:
+			subs = append(subs, f)
+			langs = append(langs, subBase)
+		}
+	}
+	return subs, langs
+}
+
+// Creates the subtitle responses of a video
+func createSubtitlesRes(idx *subtitleIndex, video *torrent.File) []apiSubtitle {
+	res := []apiSubtitle{}
+	subs, langs := idx.find(video)
+	for i, sub := range subs {
+		res = append(res, apiSubtitle{
+			FileName: sub.DisplayPath(),
+			Language: langs[i],
+			Link:     createSubtitleLink(sub),
+		})
+	}
+	return res
+}
+
+// Creates a URL for the subtitle, converted to WebVTT if it is SRT
+func createSubtitleLink(sub *torrent.File) string {
+	link := createAPILink("subtitle", sub.Torrent().InfoHash().String(), torrentFileIndex(sub))
+	if strings.ToLower(path.Ext(sub.DisplayPath())) == ".srt" {
+		if strings.Contains(link, "?") {
+			return link + "&format=vtt"
+		}
+		return link + "?format=vtt"
+	}
+	return link
+}
+
+// Converts SRT subtitles to WebVTT
+func srtToVTT(srt []byte) []byte {
+	/* Strip BOM and normalize line endings */
+	srt = bytes.TrimPrefix(srt, []byte("\xef\xbb\xbf"))
+	srt = bytes.ReplaceAll(srt, []byte("\r\n"), []byte("\n"))
+
+	vtt := bytes.NewBufferString("WEBVTT\n\n")
+	for _, line := range strings.Split(string(srt), "\n") {
+		/* WebVTT uses dots as the decimal separator of timings */
+		if strings.Contains(line, "-->") {
+			line = srtTimingRegexp.ReplaceAllString(line, "$1.$2")
+		}
+		vtt.WriteString(line + "\n")
+	}
+	return vtt.Bytes()
+}
+
+// Endpoint for serving a subtitle file
+func apiSubtitleFile(w http.ResponseWriter, r *http.Request) {
+	/* Get torrent and file handles from infohash and file index or filename */
+	t, f, ok := requestTorrentFile(w, r)
+	if !ok {
+		return
+	}
+	if !isSubtitleFile(f.DisplayPath()) {
+		errorRes(w, "File is not a subtitle", http.StatusBadRequest)
+		return
+	}
+
+	/* Subtitles are small so they are read whole, limited as reads can overrun the file end */
+	reader := f.NewReader()
+	defer reader.Close()
+	sub, rerr := io.ReadAll(io.LimitReader(reader, f.Length()))
+	if rerr != nil {
+		errorResDetails(w, "Subtitle reading error", rerr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "vtt" && strings.ToLower(path.Ext(f.DisplayPath())) == ".srt" {
+		w.Header().Set("Content-Type", mediaTypes[".vtt"])
+		w.Write(srtToVTT(sub))
+		return
+	}
+	serveTorrentFile(w, r, t, f, bytes.NewReader(sub))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFindSubtitles(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{
+		// Lowercasing "İ" makes the names longer
+		"İstanbul.mkv":        "v",
+		"İstanbul.srt":        "s",
+		"i̇stanbul.en.srt":    "s",
+		"Other.mkv":           "v",
+		"Subs/Other.fr.srt":   "s",
+		"Subs/Other/de.srt":   "s",
+		"Unrelated.srt":       "s",
+		"Extras/Other.es.ass": "s",
+	})
+	idx := newSubtitleIndex(tt)
+
+	found := map[string][]string{}
+	for _, f := range tt.Files() {
+		subs, langs := idx.find(f)
+		for i, sub := range subs {
+			found[f.DisplayPath()] = append(found[f.DisplayPath()], sub.DisplayPath()+":"+langs[i])
+		}
+	}
+
+	want := []string{"İstanbul.srt:"}
+	if got := found["İstanbul.mkv"]; !slices.Equal(got, want) {
+		t.Errorf("Subtitles of İstanbul.mkv are %v, want %v", got, want)
+	}
+	want = []string{"Subs/Other.fr.srt:fr", "Subs/Other/de.srt:de"}
+	if got := found["Other.mkv"]; !slices.Equal(got, want) {
+		t.Errorf("Subtitles of Other.mkv are %v, want %v", got, want)
+	}
+}
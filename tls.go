@@ -0,0 +1,156 @@
+/* Contains functions for serving the API over TLS */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Resolves the certificate and key paths, generating a self-signed pair if requested
+func setupTLS(certPath string, keyPath string, selfSigned bool) (string, string, error) {
+	/* TLS is disabled if no certificate is given nor generated */
+	if certPath == "" && keyPath == "" && !selfSigned {
+		return "", "", nil
+	}
+
+	/* Use default paths inside the download directory for self-signed pairs */
+	if selfSigned {
+		if certPath == "" {
+			certPath = filepath.Join(btEngine.ClientConfig.DataDir, ".torrenttp.crt")
+		}
+		if keyPath == "" {
+			keyPath = filepath.Join(btEngine.ClientConfig.DataDir, ".torrenttp.key")
+		}
+	}
+
+	if certPath == "" || keyPath == "" {
+		return "", "", errors.New("both certificate and key must be provided")
+	}
+
+	/* Generate the pair on first start */
+	if selfSigned && (!fileExists(certPath) || !fileExists(keyPath)) {
+		Info.Printf("Generating self-signed certificate: %s\n", certPath)
+		if err := generateSelfSignedCert(certPath, keyPath); err != nil {
+			return "", "", err
+		}
+	}
+
+	/* Check if the pair is usable before serving */
+	if _, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+		return "", "", err
+	}
+	return certPath, keyPath, nil
+}
+
+// Creates the TLS config of the HTTP server with HTTP/2 enabled
+func newTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+}
+
+// Creates a self-signed ECDSA certificate and writes it and its key as PEM files
+func generateSelfSignedCert(certPath string, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, serr := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if serr != nil {
+		return serr
+	}
+
+	/* Valid for localhost and the machine's hostname */
+	dnsNames := []string{"localhost"}
+	if hostname, herr := os.Hostname(); herr == nil && hostname != "localhost" {
+		dnsNames = append(dnsNames, hostname)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"torrenttp"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	der, derr := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if derr != nil {
+		return derr
+	}
+	keyDer, kerr := x509.MarshalPKCS8PrivateKey(key)
+	if kerr != nil {
+		return kerr
+	}
+
+	/* Write PEM files, the key being only readable by the owner */
+	if err := os.MkdirAll(filepath.Dir(certPath), 0755); err != nil {
+		return err
+	}
+	if err := writePEM(certPath, "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+	return writePEM(keyPath, "PRIVATE KEY", keyDer, 0600)
+}
+
+// Writes a single PEM block to a file
+func writePEM(path string, blockType string, der []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if perr := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); perr != nil {
+		f.Close()
+		return perr
+	}
+	return f.Close()
+}
+
+// Check if a file exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Handler redirecting every plain HTTP request to the HTTPS listener
+func redirectToHTTPS(tlsPort string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsPort)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		/* Strip the port of the plain HTTP listener */
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+
+		/* Use the port of the HTTPS listener if not the default */
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
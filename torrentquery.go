@@ -0,0 +1,183 @@
+/* Contains the filtering, sorting, pagination and field selection of torrent stats */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Filters, sort key, page and fields of a torrent stats request
+type torrentQuery struct {
+	State  string
+	Search string
+	Label  string
+	Sort   string
+	Desc   bool
+	Limit  int
+	Offset int
+	Fields []string
+}
+
+var (
+	/* Filters of the torrent state */
+	torrentStates = map[string]func(t apiTorrentStasResTorrents) bool{
+		"completed":  func(t apiTorrentStasResTorrents) bool { return t.Completed },
+		"incomplete": func(t apiTorrentStasResTorrents) bool { return !t.Completed },
+		"downloading": func(t apiTorrentStasResTorrents) bool {
+			/* Stalled and fully skipped torrents are not downloading */
+			return !t.Completed && t.ETA != 0 && (t.DownloadSpeedBytes > 0 || t.ActivePeers > 0)
+		},
+		"seeding": func(t apiTorrentStasResTorrents) bool { return t.Seeding },
+		"active": func(t apiTorrentStasResTorrents) bool {
+			return t.DownloadSpeedBytes > 0 || t.UploadSpeedBytes > 0
+		},
+	}
+
+	/* Ascending orders of the sort keys */
+	torrentSortKeys = map[string]func(a, b apiTorrentStasResTorrents) bool{
+		"name":          func(a, b apiTorrentStasResTorrents) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) },
+		"added":         func(a, b apiTorrentStasResTorrents) bool { return a.AddedAt.Before(b.AddedAt) },
+		"size":          func(a, b apiTorrentStasResTorrents) bool { return a.SizeBytes < b.SizeBytes },
+		"progress":      func(a, b apiTorrentStasResTorrents) bool { return a.ProgressFraction < b.ProgressFraction },
+		"downloadspeed": func(a, b apiTorrentStasResTorrents) bool { return a.DownloadSpeedBytes < b.DownloadSpeedBytes },
+		"uploadspeed":   func(a, b apiTorrentStasResTorrents) bool { return a.UploadSpeedBytes < b.UploadSpeedBytes },
+		"ratio":         func(a, b apiTorrentStasResTorrents) bool { return a.Ratio < b.Ratio },
+		"peers":         func(a, b apiTorrentStasResTorrents) bool { return a.ActivePeers < b.ActivePeers },
+	}
+)
+
+// Parses the query parameters of a torrent stats request
+func parseTorrentQuery(r *http.Request) (torrentQuery, error) {
+	q := r.URL.Query()
+	query := torrentQuery{
+		State:  q.Get("state"),
+		Search: strings.ToLower(q.Get("search")),
+		Label:  q.Get("label"),
+		Sort:   "name",
+	}
+
+	if _, ok := torrentStates[query.State]; query.State != "" && !ok {
+		return query, errors.New("unknown state: " + query.State)
+	}
+
+	/* A leading minus sorts in descending order */
+	if s := q.Get("sort"); s != "" {
+		query.Sort, query.Desc = strings.CutPrefix(s, "-")
+		if _, ok := torrentSortKeys[query.Sort]; !ok {
+			return query, errors.New("unknown sort key: " + query.Sort)
+		}
+	}
+
+	var err error
+	if l := q.Get("limit"); l != "" {
+		if query.Limit, err = strconv.Atoi(l); err != nil || query.Limit < 0 {
+			return query, errors.New("invalid limit: " + l)
+		}
+	}
+	if o := q.Get("offset"); o != "" {
+		if query.Offset, err = strconv.Atoi(o); err != nil || query.Offset < 0 {
+			return query, errors.New("invalid offset: " + o)
+		}
+	}
+
+	if f := q.Get("fields"); f != "" {
+		known := jsonFieldNames(reflect.TypeOf(apiTorrentStasResTorrents{}))
+		for _, field := range splitList(f) {
+			if !slices.Contains(known, field) {
+				return query, errors.New("unknown field: " + field)
+			}
+			query.Fields = append(query.Fields, field)
+		}
+	}
+	return query, nil
+}
+
+// Check if the field is selected, all of them being selected by default
+func (query torrentQuery) hasField(field string) bool {
+	return len(query.Fields) == 0 || slices.Contains(query.Fields, field)
+}
+
+// Check if the torrent matches the state and label filters and name search
+func (query torrentQuery) match(t apiTorrentStasResTorrents) bool {
+	if query.State != "" && !torrentStates[query.State](t) {
+		return false
+	}
+	if query.Label != "" && !slices.Contains(t.Labels, query.Label) {
+		return false
+	}
+	return query.Search == "" || strings.Contains(strings.ToLower(t.Name), query.Search)
+}
+
+// Sorts the torrents by the sort key, ties being ordered by infohash
+func (query torrentQuery) sort(torrents []apiTorrentStasResTorrents) {
+	less := torrentSortKeys[query.Sort]
+	sort.SliceStable(torrents, func(i, j int) bool {
+		a, b := torrents[i], torrents[j]
+		if query.Desc {
+			a, b = b, a
+		}
+		if less(a, b) != less(b, a) {
+			return less(a, b)
+		}
+		return torrents[i].InfoHash < torrents[j].InfoHash
+	})
+}
+
+// Returns the page of the torrents, all the remaining ones without limit
+func (query torrentQuery) page(torrents []apiTorrentStasResTorrents) []apiTorrentStasResTorrents {
+	if query.Offset >= len(torrents) {
+		return []apiTorrentStasResTorrents{}
+	}
+	torrents = torrents[query.Offset:]
+	if query.Limit > 0 && query.Limit < len(torrents) {
+		torrents = torrents[:query.Limit]
+	}
+	return torrents
+}
+
+// Keeps only the selected fields of the torrent stats
+func selectTorrentFields(res apiTorrentStasRes, fields []string) (apiTorrentStatsFieldsRes, error) {
+	sres := apiTorrentStatsFieldsRes{
+		Torrents:           []map[string]any{},
+		Total:              res.Total,
+		BlockedConnections: res.BlockedConnections,
+	}
+	for _, t := range res.Torrents {
+		b, err := json.Marshal(t)
+		if err != nil {
+			return sres, err
+		}
+		all := map[string]any{}
+		if err := json.Unmarshal(b, &all); err != nil {
+			return sres, err
+		}
+
+		selected := map[string]any{}
+		for _, field := range fields {
+			if v, ok := all[field]; ok {
+				selected[field] = v
+			}
+		}
+		sres.Torrents = append(sres.Torrents, selected)
+	}
+	return sres, nil
+}
+
+// Returns the JSON names of the fields of a struct
+func jsonFieldNames(t reflect.Type) []string {
+	names := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+// Stalled torrents are not downloading
+func TestDownloadingState(t *testing.T) {
+	downloading := torrentStates["downloading"]
+	stalled := apiTorrentStasResTorrents{ETA: -1}
+	if downloading(stalled) {
+		t.Errorf("Stalled torrent is downloading")
+	}
+	if !downloading(apiTorrentStasResTorrents{ETA: -1, ActivePeers: 1}) {
+		t.Errorf("Torrent with active peers is not downloading")
+	}
+	if !downloading(apiTorrentStasResTorrents{ETA: 10, DownloadSpeedBytes: 100}) {
+		t.Errorf("Receiving torrent is not downloading")
+	}
+	if downloading(apiTorrentStasResTorrents{ETA: 0, ActivePeers: 1}) {
+		t.Errorf("Torrent with nothing wanted is downloading")
+	}
+}
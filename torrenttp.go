@@ -3,9 +3,11 @@ package main
 import (
 	"flag"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gorilla/mux"
-	"github.com/rs/cors"
 )
 
 func main() {
@@ -13,51 +15,180 @@ func main() {
 	dirFlag := flag.String("dir", "torrenttpdl", "Download directory path")
 	portFlag := flag.String("port", ":1010", "HTTP server listening port")
 	noupFlag := flag.Bool("noup", false, "Disables BT client upload")
+	seedFlag := flag.Bool("seed", false, "Keep uploading completed torrents")
 	authFlag := flag.Bool("auth", false, "Enable API key authentication from the env varible TORRENTTPKEY")
+	tlsCertFlag := flag.String("tls-cert", "", "TLS certificate file path, enables HTTPS")
+	tlsKeyFlag := flag.String("tls-key", "", "TLS private key file path")
+	tlsSelfSignedFlag := flag.Bool("tls-selfsigned", false, "Generate a self-signed certificate on first start if none is found")
+	tlsRedirectFlag := flag.String("tls-redirect", "", "HTTP server listening port that redirects to HTTPS")
+	var listenFlag listenAddrs
+	flag.Var(&listenFlag, "listen", "Additional listener as tcp:ADDR or unix:PATH, can be repeated")
+	socketPermFlag := flag.String("socket-perm", "0660", "Unix socket file permissions")
+	trustedProxiesFlag := flag.String("trusted-proxies", "", "Comma separated IPs or CIDR ranges of reverse proxies trusted to give the client IP")
+	corsOriginsFlag := flag.String("cors-origins", "*", "Comma separated CORS allowed origins, empty to disallow all")
+	corsMethodsFlag := flag.String("cors-methods", "GET,POST,PUT,PATCH,DELETE", "Comma separated CORS allowed methods")
+	corsHeadersFlag := flag.String("cors-headers", "Content-Type,Authorization", "Comma separated CORS allowed headers")
+	corsCredentialsFlag := flag.Bool("cors-credentials", false, "Allow CORS requests with credentials")
+	ffmpegFlag := flag.String("ffmpeg", "ffmpeg", "ffmpeg binary used for HLS streaming")
+	ffprobeFlag := flag.String("ffprobe", "ffprobe", "ffprobe binary used for HLS streaming")
+	hlsSegmentFlag := flag.Float64("hls-segment", 6, "HLS segment length in seconds")
+	blocklistFlag := flag.String("blocklist", "", "IP blocklist file in eMule DAT or PeerGuardian P2P format, plain or gzipped")
+	trackersFlag := flag.String("trackers", "", "Default tracker list file of one URL per line, added to every torrent")
+	geoIPFlag := flag.String("geoip", "", "GeoIP CSV database of start IP, end IP and country code rows")
 	flag.Parse()
 
 	// Check if authentication is enabled
 	checkAuthEnabled(*authFlag)
 
+	// Trusts the client IP given by the reverse proxies
+	if perr := initTrustedProxies(*trustedProxiesFlag); perr != nil {
+		Error.Fatalf("Invalid trusted proxy: %s\n", perr)
+	}
+
 	// Creates the BitTorrent client with user args
-	btEngine.initialize(newBtCliConfs(*dirFlag, *noupFlag))
+	btEngine.initialize(newBtCliConfs(*dirFlag, *noupFlag, *seedFlag))
+
+	// Loads the default trackers before the persistent specs
+	initDefaultTrackers(*trackersFlag)
 
 	/* Initilize DB and load persistent specs */
 	dberr := createSpecBucket()
 	if dberr != nil {
 		Error.Fatalf("Cannot initialize DB: %s\n", dberr)
 	}
+
+	/* Initialize and apply the persistent ban list before any peer connects */
+	banerr := createBanBucket()
+	if banerr == nil {
+		banerr = loadBans()
+	}
+	if banerr != nil {
+		Error.Fatalf("Cannot load bans: %s\n", banerr)
+	}
+
+	// Blocks the ranges of the blocklist file
+	initBlocklist(*blocklistFlag)
 	go loadPersist()
 
+	// Enables the country lookup of peers
+	initGeoIP(*geoIPFlag)
+
+	// Enables HLS streaming if ffmpeg is available
+	initHLS(*ffmpegFlag, *ffprobeFlag, *hlsSegmentFlag)
+
+	/* Resolve TLS certificate */
+	certFile, keyFile, tlserr := setupTLS(*tlsCertFlag, *tlsKeyFlag, *tlsSelfSignedFlag)
+	if tlserr != nil {
+		Error.Fatalf("Cannot initialize TLS: %s\n", tlserr)
+	}
+
 	/* Initialize endpoints and HTTP server */
 	r := mux.NewRouter().StrictSlash(true)
-	r.Use(checkAuth)
 
-	/* Handlers for endpoints */
+	/* API v2 with proper status codes and structured errors */
+	v2 := r.PathPrefix("/api/v2").Subrouter()
+	v2.Use(apiV2, checkAuth)
+	apiRoutes(v2)
+
+	/* API v1 kept for compatibility */
+	v1 := r.PathPrefix("/api").Subrouter()
+	v1.Use(checkAuth)
+	apiRoutes(v1)
+
+	/* Build OpenAPI specification from the registered routes */
+	initOpenAPI(r)
+
+	/* CORS middleware */
+	c := newCorsPolicy(*corsOriginsFlag, *corsMethodsFlag, *corsHeadersFlag, *corsCredentialsFlag).Handler(r)
 
+	/* Open the listeners, -port being skipped if only -listen is given */
+	addrs := []string(listenFlag)
+	if len(addrs) == 0 || isFlagSet("port") {
+		addrs = append([]string{"tcp:" + *portFlag}, addrs...)
+	}
+	listeners, lerr := openListeners(addrs, *socketPermFlag)
+	if lerr != nil {
+		Error.Fatalf("Cannot open listener: %s\n", lerr)
+	}
+
+	srv := &http.Server{Handler: c}
+
+	if certFile != "" {
+		/* HTTPS server with HTTP/2 */
+		srv.TLSConfig = newTLSConfig()
+
+		/* Redirects plain HTTP requests to the first HTTPS listener */
+		if *tlsRedirectFlag != "" {
+			tlsAddr := firstTCPListener(listeners)
+			go func() {
+				Info.Printf("Starting HTTPS redirect server on port: %s", *tlsRedirectFlag)
+				Error.Fatalln(http.ListenAndServe(*tlsRedirectFlag, redirectToHTTPS(tlsAddr)))
+			}()
+		}
+	} else if *tlsRedirectFlag != "" {
+		Warn.Println("HTTPS redirect is ignored as TLS is disabled")
+	}
+
+	/* Close listeners on exit to remove unix socket files */
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		Info.Println("Shutting down HTTP server")
+		btEngine.saveUploads()
+		srv.Close()
+	}()
+
+	serr := serveListeners(srv, listeners, certFile, keyFile)
+	if serr != http.ErrServerClosed {
+		Error.Fatalln(serr)
+	}
+}
+
+// Registers the handlers for endpoints on an API version router
+func apiRoutes(r *mux.Router) {
 	/* POST */
-	r.HandleFunc("/api/addtorrent", apiAddTorrent).Methods("POST")
-	r.HandleFunc("/api/selectfile", apiTorrentSelectFile).Methods("POST")
-	r.HandleFunc("/api/setpriority", apiTorrentPriorityFile).Methods("POST")
-	r.HandleFunc("/api/addtorrentfile", apiAddTorrentFile).Methods("POST")
+	r.HandleFunc("/addtorrent", apiAddTorrent).Methods("POST")
+	r.HandleFunc("/selectfile", apiTorrentSelectFile).Methods("POST")
+	r.HandleFunc("/setpriority", apiTorrentPriorityFile).Methods("POST")
+	r.HandleFunc("/addtorrentfile", apiAddTorrentFile).Methods("POST")
+	r.HandleFunc("/play", apiDirectPlay).Methods("POST")
+	r.HandleFunc("/torrents/{infohash}/peers", apiAddPeers).Methods("POST")
+	r.HandleFunc("/torrents/{infohash}/trackers", apiAddTrackers).Methods("POST")
+	r.HandleFunc("/bans", apiAddBans).Methods("POST")
+	r.HandleFunc("/blocklist/reload", apiReloadBlocklist).Methods("POST")
+	r.HandleFunc("/trackers/reload", apiReloadDefaultTrackers).Methods("POST")
+
+	/* PATCH */
+	r.HandleFunc("/torrents/{infohash}", apiEditTorrent).Methods("PATCH")
 
 	/* DELETE */
-	r.HandleFunc("/api/removetorrent", apiRemoveTorrent).Methods("DELETE")
+	r.HandleFunc("/removetorrent", apiRemoveTorrent).Methods("DELETE")
+	r.HandleFunc("/streams/{id}", apiKillStreamSession).Methods("DELETE")
+	r.HandleFunc("/torrents/{infohash}/peers/{peer}", apiDisconnectPeer).Methods("DELETE")
+	r.HandleFunc("/torrents/{infohash}/trackers", apiRemoveTrackers).Methods("DELETE")
+	r.HandleFunc("/bans", apiRemoveBans).Methods("DELETE")
 
 	/* GET */
-	r.HandleFunc("/api/stream/{infohash}/{file:.*}", apiStreamTorrentFile).Methods("GET")
-	r.HandleFunc("/api/file/{infohash}/{file:.*}", apiDownloadFile).Methods("GET")
-	r.HandleFunc("/api/torrents", apiTorrentStats).Methods("GET")
-	r.HandleFunc("/api/torrents/{infohash}", apiTorrentStats).Methods("GET")
-	r.HandleFunc("/api/play", apiDirectPlay).Methods("GET")
-
-	/* CORS middleware */
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "DELETE"},
-		AllowCredentials: true,
-	}).Handler(r)
-
-	Info.Printf("Starting HTTP server on port: %s", *portFlag)
-	Error.Fatalln(http.ListenAndServe(*portFlag, c))
+	// Files by index are under an "index" segment, which is never an infohash
+	r.HandleFunc("/stream/index/{infohash}/{index:[0-9]+}", apiStreamTorrentFile).Methods("GET")
+	r.HandleFunc("/stream/{infohash}/{file:.*}", apiStreamTorrentFile).Methods("GET")
+	r.HandleFunc("/file/index/{infohash}/{index:[0-9]+}", apiDownloadFile).Methods("GET")
+	r.HandleFunc("/file/{infohash}/{file:.*}", apiDownloadFile).Methods("GET")
+	r.HandleFunc("/archive/{infohash}", apiArchive).Methods("GET")
+	r.HandleFunc("/subtitle/index/{infohash}/{index:[0-9]+}", apiSubtitleFile).Methods("GET")
+	r.HandleFunc("/subtitle/{infohash}/{file:.*}", apiSubtitleFile).Methods("GET")
+	r.HandleFunc("/hls/index/{infohash}/{index:[0-9]+}/index.m3u8", apiHLSPlaylist).Methods("GET")
+	r.HandleFunc("/hls/index/{infohash}/{index:[0-9]+}/{segment:[0-9]+}.ts", apiHLSSegment).Methods("GET")
+	r.HandleFunc("/hls/{infohash}/{file:.*}/index.m3u8", apiHLSPlaylist).Methods("GET")
+	r.HandleFunc("/hls/{infohash}/{file:.*}/{segment:[0-9]+}.ts", apiHLSSegment).Methods("GET")
+	r.HandleFunc("/torrents", apiTorrentStats).Methods("GET")
+	r.HandleFunc("/torrents/{infohash}", apiTorrentStats).Methods("GET")
+	r.HandleFunc("/torrents/{infohash}/trackers", apiTrackers).Methods("GET")
+	r.HandleFunc("/play", apiDirectPlay).Methods("GET")
+	r.HandleFunc("/playfile", apiPlayFile).Methods("GET")
+	r.HandleFunc("/streams", apiStreamSessions).Methods("GET")
+	r.HandleFunc("/bans", apiBans).Methods("GET")
+	r.HandleFunc("/trackers", apiDefaultTrackers).Methods("GET")
+	r.HandleFunc("/openapi.json", apiOpenAPI).Methods("GET")
 }
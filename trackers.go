@@ -0,0 +1,420 @@
+/* Contains the listing and management of the trackers of torrents */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/tracker"
+	"github.com/anacrolix/torrent/tracker/udp"
+	"github.com/gorilla/mux"
+)
+
+// Last scrape of a tracker for a torrent
+type trackerScrape struct {
+	At          time.Time
+	Err         error
+	Unsupported bool
+	Info        udp.ScrapeInfohashResult
+}
+
+// Consecutive scrape failures of a tracker and when to try it again
+type trackerBackoff struct {
+	Failures int
+	RetryAt  time.Time
+}
+
+const (
+	/* Trackers are scraped in the background at this interval */
+	trackerScrapeInterval = time.Minute
+
+	/* Time given to a tracker to answer a scrape */
+	trackerScrapeTimeout = 5 * time.Second
+
+	/* Trackers scraped at the same time */
+	trackerScrapeWorkers = 4
+
+	/* Infohashes asked in one scrape, UDP trackers answering about 70 at most */
+	trackerScrapeBatch = 50
+
+	/* Longest wait before scraping a failing tracker again */
+	trackerScrapeMaxBackoff = 30 * time.Minute
+)
+
+var (
+	/* Last scrapes by infohash then tracker URL */
+	trackerScrapes   = map[string]map[string]trackerScrape{}
+	trackerBackoffs  = map[string]trackerBackoff{}
+	trackerScrapesMu sync.Mutex
+)
+
+// Returns the tracker tiers of a torrent, empty tiers being skipped
+func torrentTrackerTiers(t *torrent.Torrent) [][]string {
+	tiers := [][]string{}
+	for _, tier := range t.Metainfo().AnnounceList {
+		if len(tier) > 0 {
+			tiers = append(tiers, slices.Clone(tier))
+		}
+	}
+	return tiers
+}
+
+// Check if a tracker is in the tiers
+func hasTracker(tiers [][]string, tracker string) bool {
+	for _, tier := range tiers {
+		if slices.Contains(tier, tracker) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scrapes the trackers of every torrent at each interval
+func scrapeTrackers() {
+	for {
+		scrapeTorrents(btEngine.Client.Torrents())
+		time.Sleep(trackerScrapeInterval)
+	}
+}
+
+// Scrapes the trackers of the torrents with a few workers, each tracker once for all its torrents
+func scrapeTorrents(torrents []*torrent.Torrent) {
+	byTracker := map[string][]*torrent.Torrent{}
+	for _, t := range torrents {
+		for _, tier := range torrentTrackerTiers(t) {
+			for _, tr := range tier {
+				if !slices.Contains(byTracker[tr], t) {
+					byTracker[tr] = append(byTracker[tr], t)
+				}
+			}
+		}
+	}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < trackerScrapeWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tr := range jobs {
+				scrapeTracker(tr, byTracker[tr])
+			}
+		}()
+	}
+	for tr := range byTracker {
+		if trackerBackingOff(tr) {
+			continue
+		}
+		jobs <- tr
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// Asks a tracker for the seeders, leechers and downloads of torrents, keeping the results for the responses
+func scrapeTracker(trackerURL string, torrents []*torrent.Torrent) {
+	at := time.Now()
+	setAll := func(s trackerScrape) {
+		for _, t := range torrents {
+			setTrackerScrape(t, trackerURL, s)
+		}
+	}
+	cl, err := tracker.NewClient(trackerURL, tracker.NewClientOpts{})
+	if errors.Is(err, tracker.ErrBadScheme) {
+		// Websocket trackers cannot be scraped
+		setAll(trackerScrape{At: at, Unsupported: true})
+		return
+	}
+	if err != nil {
+		setAll(trackerScrape{At: at, Err: err})
+		setTrackerBackoff(trackerURL, true)
+		return
+	}
+	defer cl.Close()
+
+	failed := false
+	for start := 0; start < len(torrents); start += trackerScrapeBatch {
+		batch := torrents[start:min(start+trackerScrapeBatch, len(torrents))]
+		ihs := []torrent.InfoHash{}
+		for _, t := range batch {
+			ihs = append(ihs, t.InfoHash())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), trackerScrapeTimeout)
+		res, serr := cl.Scrape(ctx, ihs)
+		cancel()
+		if serr == nil && len(res) < len(batch) {
+			serr = errors.New("incomplete scrape response")
+		}
+		for i, t := range batch {
+			s := trackerScrape{At: at, Err: serr}
+			if serr == nil {
+				s.Info = res[i]
+			}
+			setTrackerScrape(t, trackerURL, s)
+		}
+		failed = failed || serr != nil
+	}
+	setTrackerBackoff(trackerURL, failed)
+}
+
+// Check if a failing tracker must not be scraped yet
+func trackerBackingOff(trackerURL string) bool {
+	trackerScrapesMu.Lock()
+	defer trackerScrapesMu.Unlock()
+	return time.Now().Before(trackerBackoffs[trackerURL].RetryAt)
+}
+
+// Doubles the wait before scraping a tracker again after each failure, resetting it on success
+func setTrackerBackoff(trackerURL string, failed bool) {
+	trackerScrapesMu.Lock()
+	defer trackerScrapesMu.Unlock()
+	if !failed {
+		delete(trackerBackoffs, trackerURL)
+		return
+	}
+	b := trackerBackoffs[trackerURL]
+	b.Failures++
+	wait := trackerScrapeMaxBackoff
+	if b.Failures < 6 {
+		wait = min(trackerScrapeInterval<<b.Failures, trackerScrapeMaxBackoff)
+	}
+	b.RetryAt = time.Now().Add(wait)
+	trackerBackoffs[trackerURL] = b
+}
+
+// Keeps the scrape of a tracker unless the torrent or the tracker was removed meanwhile
+func setTrackerScrape(t *torrent.Torrent, trackerURL string, s trackerScrape) {
+	if _, ok := btEngine.Client.Torrent(t.InfoHash()); !ok || !hasTracker(torrentTrackerTiers(t), trackerURL) {
+		return
+	}
+
+	trackerScrapesMu.Lock()
+	defer trackerScrapesMu.Unlock()
+	ih := t.InfoHash().String()
+	if trackerScrapes[ih] == nil {
+		trackerScrapes[ih] = map[string]trackerScrape{}
+	}
+	trackerScrapes[ih][trackerURL] = s
+}
+
+// Returns the last scrape of a tracker, false if it was not scraped yet
+func getTrackerScrape(infohash string, trackerURL string) (trackerScrape, bool) {
+	trackerScrapesMu.Lock()
+	defer trackerScrapesMu.Unlock()
+	s, ok := trackerScrapes[infohash][trackerURL]
+	return s, ok
+}
+
+// Removes the scrapes of trackers of a torrent, all of them if trackers is nil
+func forgetTrackerScrapes(infohash string, trackers []string) {
+	trackerScrapesMu.Lock()
+	defer trackerScrapesMu.Unlock()
+	if trackers == nil {
+		delete(trackerScrapes, infohash)
+		return
+	}
+	for _, tr := range trackers {
+		delete(trackerScrapes[infohash], tr)
+	}
+}
+
+// Creates the response of the trackers of a torrent from their last scrapes
+func createTrackersRes(t *torrent.Torrent) apiTrackersRes {
+	res := apiTrackersRes{
+		InfoHash: t.InfoHash().String(),
+		Trackers: []apiTracker{},
+	}
+	for i, tier := range torrentTrackerTiers(t) {
+		for _, tr := range tier {
+			res.Trackers = append(res.Trackers, createTrackerRes(res.InfoHash, tr, i, isTorrentDefaultTracker(t, tr)))
+		}
+	}
+	return res
+}
+
+// Creates the response of a tracker of a torrent from its last scrape, pending until the first one
+func createTrackerRes(infohash string, trackerURL string, tier int, isDefault bool) apiTracker {
+	res := apiTracker{
+		URL:          trackerURL,
+		Tier:         tier,
+		Default:      isDefault,
+		ScrapeStatus: "pending",
+	}
+	s, ok := getTrackerScrape(infohash, trackerURL)
+	switch {
+	case !ok:
+		return res
+	case s.Unsupported:
+		res.ScrapeStatus = "unsupported"
+		return res
+	case s.Err != nil:
+		res.ScrapeStatus = "error"
+		res.ScrapeError = s.Err.Error()
+	default:
+		res.ScrapeStatus = "working"
+		res.Seeders = s.Info.Seeders
+		res.Leechers = s.Info.Leechers
+		res.Downloaded = s.Info.Completed
+	}
+	res.LastScrape = &s.At
+	return res
+}
+
+// Parses the tracker URLs of a request body
+func decodeTrackersBody(w http.ResponseWriter, r *http.Request) (apiTrackersBody, bool) {
+	body := apiTrackersBody{}
+	if decodeBody(w, r.Body, &body) != nil {
+		return body, false
+	}
+	if len(body.Trackers) < 1 {
+		errorRes(w, "No trackers provided", http.StatusBadRequest)
+		return body, false
+	}
+
+	for i, tr := range body.Trackers {
+		body.Trackers[i] = strings.TrimSpace(tr)
+		u, err := url.Parse(body.Trackers[i])
+		if err == nil && u.Host == "" {
+			err = errors.New("missing host")
+		}
+		if err != nil {
+			errorResDetails(w, "Invalid tracker URL", err.Error(), http.StatusBadRequest)
+			return body, false
+		}
+	}
+	return body, true
+}
+
+// Adds the trackers missing from a torrent to a tier, or each to a new tier if it is nil
+func addTorrentTrackers(t *torrent.Torrent, trackers []string, tier *int) bool {
+	/* Tiers are given without the empty ones, which the client still counts */
+	raw := t.Metainfo().AnnounceList
+	tierIndexes := []int{}
+	for i, tier := range raw {
+		if len(tier) > 0 {
+			tierIndexes = append(tierIndexes, i)
+		}
+	}
+	if tier != nil && (*tier < 0 || *tier >= len(tierIndexes)) {
+		return false
+	}
+
+	/* Trackers already present are skipped */
+	current := torrentTrackerTiers(t)
+	announceList := make([][]string, len(raw))
+	for _, tr := range trackers {
+		if hasTracker(current, tr) || hasTracker(announceList, tr) {
+			continue
+		}
+		if tier != nil {
+			i := tierIndexes[*tier]
+			announceList[i] = append(announceList[i], tr)
+		} else {
+			announceList = append(announceList, []string{tr})
+		}
+	}
+	t.AddTrackers(announceList)
+	return true
+}
+
+// Persists the current trackers of a torrent, the default ones being added again on restart
+func saveTrackers(t *torrent.Torrent) error {
+	tiers := withoutDefaultTrackers(t, torrentTrackerTiers(t))
+	return updateSpec(t.InfoHash().String(), func(spec *persistentSpec) {
+		spec.Trackers = tiers
+	})
+}
+
+// Endpoint listing the trackers of a torrent with their scrape status
+func apiTrackers(w http.ResponseWriter, r *http.Request) {
+	t, err := btEngine.getTorrHandle(mux.Vars(r)["infohash"])
+	if err != nil {
+		torrHandleErrorRes(w, err)
+		return
+	}
+
+	res := createTrackersRes(t)
+	encodeRes(w, &res)
+}
+
+// Endpoint adding trackers to a tier of a torrent, or each to a new tier
+func apiAddTrackers(w http.ResponseWriter, r *http.Request) {
+	t, err := btEngine.getTorrHandle(mux.Vars(r)["infohash"])
+	if err != nil {
+		torrHandleErrorRes(w, err)
+		return
+	}
+
+	body, ok := decodeTrackersBody(w, r)
+	if !ok {
+		return
+	}
+
+	if !addTorrentTrackers(t, body.Trackers, body.Tier) {
+		errorRes(w, "Invalid tier", http.StatusBadRequest)
+		return
+	}
+	ownTrackers(t, body.Trackers)
+	go scrapeTorrents([]*torrent.Torrent{t})
+
+	if serr := saveTrackers(t); serr != nil {
+		errorResDetails(w, "Cannot save trackers", serr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res := createTrackersRes(t)
+	encodeRes(w, &res)
+}
+
+// Endpoint removing trackers from a torrent
+func apiRemoveTrackers(w http.ResponseWriter, r *http.Request) {
+	t, err := btEngine.getTorrHandle(mux.Vars(r)["infohash"])
+	if err != nil {
+		torrHandleErrorRes(w, err)
+		return
+	}
+
+	body, ok := decodeTrackersBody(w, r)
+	if !ok {
+		return
+	}
+
+	current := torrentTrackerTiers(t)
+	for _, tr := range body.Trackers {
+		if !hasTracker(current, tr) {
+			errorRes(w, "Tracker not found: "+tr, http.StatusNotFound)
+			return
+		}
+	}
+
+	/* Tiers left empty are dropped */
+	tiers := [][]string{}
+	for _, tier := range current {
+		tier = slices.DeleteFunc(tier, func(tr string) bool {
+			return slices.Contains(body.Trackers, tr)
+		})
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+	t.ModifyTrackers(tiers)
+	ownTrackers(t, body.Trackers)
+	forgetTrackerScrapes(t.InfoHash().String(), body.Trackers)
+
+	if serr := saveTrackers(t); serr != nil {
+		errorResDetails(w, "Cannot save trackers", serr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res := createTrackersRes(t)
+	encodeRes(w, &res)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/tracker/udp"
+)
+
+// Trackers are pending until scraped and their scrapes are dropped with the torrent
+func TestTrackerScrapes(t *testing.T) {
+	tt := newTestTorrent(t, map[string]string{"a.txt": "a"})
+	tr := "http://127.0.0.1:1/announce"
+	tt.AddTrackers([][]string{{tr}})
+	ih := tt.InfoHash().String()
+	t.Cleanup(func() { forgetTrackerScrapes(ih, nil) })
+
+	status := func() string {
+		res := createTrackersRes(tt)
+		if len(res.Trackers) != 1 {
+			t.Fatalf("Trackers %v", res.Trackers)
+		}
+		return res.Trackers[0].ScrapeStatus
+	}
+	if s := status(); s != "pending" {
+		t.Errorf("Status %q before any scrape", s)
+	}
+
+	setTrackerScrape(tt, tr, trackerScrape{At: time.Now(), Info: udp.ScrapeInfohashResult{Seeders: 2}})
+	if s := status(); s != "working" {
+		t.Errorf("Status %q after a scrape", s)
+	}
+
+	forgetTrackerScrapes(ih, nil)
+	trackerScrapesMu.Lock()
+	_, kept := trackerScrapes[ih]
+	trackerScrapesMu.Unlock()
+	if kept {
+		t.Errorf("Scrapes kept after the torrent is forgotten")
+	}
+}
+
+// A failing tracker is not scraped again until its backoff ends
+func TestTrackerScrapeBackoff(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	tt := newTestTorrent(t, map[string]string{"a.txt": "a"})
+	tr := srv.URL + "/announce"
+	tt.AddTrackers([][]string{{tr}})
+	t.Cleanup(func() {
+		forgetTrackerScrapes(tt.InfoHash().String(), nil)
+		setTrackerBackoff(tr, false)
+	})
+
+	scrapeTorrents([]*torrent.Torrent{tt})
+	scrapeTorrents([]*torrent.Torrent{tt})
+	if n := hits.Load(); n != 1 {
+		t.Errorf("Tracker scraped %d times", n)
+	}
+	if res := createTrackersRes(tt); res.Trackers[0].ScrapeStatus != "error" {
+		t.Errorf("Tracker %+v", res.Trackers[0])
+	}
+
+	setTrackerBackoff(tr, false)
+	if trackerBackingOff(tr) {
+		t.Errorf("Backoff kept after a successful scrape")
+	}
+}